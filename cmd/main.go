@@ -1,48 +1,292 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/tysont/corduroy/core"
 	"time"
 	"github.com/jessevdk/go-flags"
 	"os"
+	"os/signal"
 	"log"
+	"reflect"
+	"strings"
+	"syscall"
 )
 
 type Options struct {
+	Config string `short:"c" long:"config" env:"CORDUROY_CONFIG" no-ini:"true" description:"Ini file of options, reread when the process receives SIGHUP" reload:"true"`
 	Port int `short:"p" long:"port" description:"Port to listen on"`
 	Path string `short:"a" long:"path" description:"Path to host endpoints"`
-	RemoteUri string `short:"u" long:"remote" description:"Remote uri of a seed node"`
-	StoreType string `short:"s" long:"store" description:"Type of store to hold data"`
+	Seeds []string `short:"u" long:"remote" env:"CORDUROY_SEEDS" env-delim:"," description:"Remote uri of a seed node, may be repeated or given as dns://host:port/path" reload:"true"`
+	StoreType string `short:"s" long:"store" description:"Type of store to hold data, memory or ordered"`
 	RegistryType string `short:"r" long:"registry" description:"Type of registry to track nodes"`
+	TLSCert string `long:"tls-cert" description:"Certificate file to serve https, reloaded when it changes"`
+	TLSKey string `long:"tls-key" description:"Private key file matching the certificate"`
+	TLSCA string `long:"tls-ca" description:"Bundle of trusted certificate authorities for peers"`
+	TLSClientAuth bool `long:"tls-client-auth" description:"Require peers to present a certificate signed by the trusted authorities"`
+	Tokens []string `long:"token" description:"Bearer token accepted for a principal as principal:token, may be repeated" reload:"true"`
+	HMACKeys []string `long:"hmac-key" description:"Shared key accepted for signed requests as id:secret, may be repeated" reload:"true"`
+	CertificateAuth bool `long:"cert-auth" description:"Authenticate clients by the common name of their verified certificate" reload:"true"`
+	Rules []string `long:"acl" description:"Access rule as principal:prefix:rw, where principal may be * and access is r, w or rw, may be repeated" reload:"true"`
+	ClusterSecret string `long:"cluster-secret" env:"CORDUROY_CLUSTER_SECRET" description:"Secret shared by peers to register and sync nodes" reload:"true"`
+	ChangeLog string `long:"changelog" description:"File to keep a durable log of changes, kept in memory when not set"`
+	ChangeLogMaxEntries int `long:"changelog-max-entries" description:"Number of changes to retain"`
+	ChangeLogMaxAge time.Duration `long:"changelog-max-age" description:"Age after which changes are discarded, such as 24h"`
+	LogLevel string `long:"log-level" env:"CORDUROY_LOG_LEVEL" description:"Least severe level to log, debug, info, warn or error" reload:"true"`
+	LogFormat string `long:"log-format" env:"CORDUROY_LOG_FORMAT" description:"Format of log lines, text or json"`
+	TraceFile string `long:"trace-file" description:"File to append finished trace spans to as json lines"`
+	Wire bool `long:"wire" description:"Send peer traffic over the binary wire protocol to peers that support it"`
+	CacheSize int `long:"cache-size" description:"Values recently forwarded to their owners to keep in a hot-key cache, off when not set"`
+	CacheTTL time.Duration `long:"cache-ttl" description:"Time a value is kept in the hot-key cache"`
+	CoalesceStoreReads bool `long:"coalesce-store-reads" description:"Share one store read between concurrent reads of the same key, for slow stores"`
+	ChunkSize int `long:"chunk-size" description:"Bytes per chunk when a large value is split across the ring"`
+	MaxObjectSize int64 `long:"max-object-size" description:"Largest value in bytes a client may write"`
+	Compress bool `long:"compress" description:"Store values gzip compressed and send them compressed to peers that support it"`
+	CompressMinSize int `long:"compress-min-size" description:"Smallest value in bytes worth compressing"`
+	MinProtocol int `long:"min-protocol" description:"Oldest peer protocol version to accept, older peers are refused"`
+	PeerTimeout time.Duration `long:"peer-timeout" description:"Time allowed for each request to a peer"`
+	PeerBulkTimeout time.Duration `long:"peer-bulk-timeout" description:"Time allowed for batch, scan and handoff requests to a peer"`
+	PeerRetries int `long:"peer-retries" description:"Times to retry a failed idempotent request to a peer"`
+	PeerMaxIdle int `long:"peer-max-idle" description:"Idle connections kept open to each peer"`
+	BreakerThreshold int `long:"breaker-threshold" description:"Consecutive failures after which a peer is skipped"`
+	BreakerCooldown time.Duration `long:"breaker-cooldown" description:"Time a failing peer is skipped before it is tried again"`
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" description:"Time allowed to hand off data and finish requests after SIGINT or SIGTERM" reload:"true"`
 }
 
 func NewOptions() *Options {
 	return &Options {
 		Port: 8080,
 		Path: "/",
-		RemoteUri: "",
+		Seeds: []string{},
 		StoreType: "memory",
 		RegistryType: "memory",
+		LogLevel: "info",
+		LogFormat: "text",
+		PeerRetries: 2,
+		ShutdownTimeout: time.Second * 30,
 	}
 }
 
-func main() {
+func parseOptions() (*Options, error) {
 	options := NewOptions()
 	_, err := flags.Parse(options)
+	if err != nil || options.Config == "" {
+		return options, err
+	}
+
+	config := options.Config
+	options = NewOptions()
+	parser := flags.NewParser(options, flags.Default)
+	err = flags.NewIniParser(parser).ParseFile(config)
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.Parse()
+	return options, err
+}
+
+func main() {
+	options, err := parseOptions()
 	if err != nil {
 		log.Fatal(err)
 		os.Exit(-1)
 	}
 
+	logger, err := buildLogger(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	store := corduroy.StoreFromShorthand(options.StoreType)
 	registry := corduroy.RegistryFromShorthand(options.RegistryType)
 	node := corduroy.NewNode(options.Port, options.Path, store, registry)
+	node.UseLogger(logger)
+	node.UsePeerClient(&corduroy.PeerClientOptions{
+		Timeout: options.PeerTimeout,
+		BulkTimeout: options.PeerBulkTimeout,
+		MaxIdleConns: options.PeerMaxIdle,
+		Retries: options.PeerRetries,
+		BreakerThreshold: options.BreakerThreshold,
+		BreakerCooldown: options.BreakerCooldown,
+	})
+	node.UseWireProtocol(options.Wire)
+	node.UseCache(&corduroy.CacheOptions{Size: options.CacheSize, TTL: options.CacheTTL})
+	node.UseStoreCoalescing(options.CoalesceStoreReads)
+	node.UseChunking(&corduroy.ChunkOptions{ChunkSize: options.ChunkSize, MaxObjectSize: options.MaxObjectSize})
+	if options.Compress {
+		node.UseCompression(&corduroy.CompressionOptions{MinSize: options.CompressMinSize})
+	}
+	if options.MinProtocol > 0 {
+		err = node.UseMinProtocol(options.MinProtocol)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if options.TLSCert != "" || options.TLSCA != "" {
+		err = node.UseTLS(&corduroy.TLSOptions{
+			CertFile: options.TLSCert,
+			KeyFile: options.TLSKey,
+			CAFile: options.TLSCA,
+			RequireClientCert: options.TLSClientAuth,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = configureAuth(node, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = node.UseChangeLog(options.ChangeLog, corduroy.ChangeRetention{
+		MaxEntries: options.ChangeLogMaxEntries,
+		MaxAge: options.ChangeLogMaxAge,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if options.TraceFile != "" {
+		exporter, err := corduroy.NewFileSpanExporter(options.TraceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		node.UseTracing(exporter)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	node.Start()
-	if options.RemoteUri != "" {
-		node.Connect(options.RemoteUri)
+	if len(options.Seeds) > 0 {
+		go func() {
+			err := node.Connect(options.Seeds...)
+			if err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	for s := range signals {
+		if s == syscall.SIGHUP {
+			options = reload(node, logger, options)
+			continue
+		}
+
+		logger.Info("shutting down", corduroy.F("signal", s.String()), corduroy.F("timeout", options.ShutdownTimeout))
+		ctx, cancel := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+		err = node.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			os.Exit(1)
+		}
+		return
 	}
+}
 
-	for {
-		time.Sleep(time.Second)
+func reload(node *corduroy.Node, logger corduroy.Logger, current *Options) *Options {
+	options, err := parseOptions()
+	var level corduroy.Level
+	if err == nil {
+		level, err = corduroy.ParseLevel(options.LogLevel)
 	}
-}
\ No newline at end of file
+	if err == nil {
+		err = configureAuth(node, options)
+	}
+	if err != nil {
+		logger.Error("unable to reload configuration, keeping the current one", corduroy.F("error", err))
+		return current
+	}
+
+	if setter, ok := logger.(corduroy.LevelSetter); ok {
+		setter.SetLevel(level)
+	}
+	node.UseSeeds(options.Seeds...)
+	for _, name := range restartOptions(current, options) {
+		logger.Warn("option changed but needs a restart to apply", corduroy.F("option", name))
+	}
+	logger.Info("reloaded configuration")
+	return options
+}
+
+func restartOptions(current *Options, updated *Options) []string {
+	changed := make([]string, 0)
+	a := reflect.ValueOf(current).Elem()
+	b := reflect.ValueOf(updated).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if field.Tag.Get("reload") == "true" || reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			continue
+		}
+		changed = append(changed, field.Tag.Get("long"))
+	}
+	return changed
+}
+
+func buildLogger(options *Options) (corduroy.Logger, error) {
+	level, err := corduroy.ParseLevel(options.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(options.LogFormat) {
+	case "text":
+		return corduroy.NewTextLogger(os.Stderr, level), nil
+	case "json":
+		return corduroy.NewJSONLogger(os.Stderr, level), nil
+	}
+	return nil, fmt.Errorf("log format '%s' should be text or json", options.LogFormat)
+}
+
+func configureAuth(node *corduroy.Node, options *Options) error {
+	authenticators := make([]corduroy.Authenticator, 0)
+	if len(options.Tokens) > 0 {
+		tokens, err := parsePairs(options.Tokens)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, corduroy.NewTokenAuthenticator(tokens))
+	}
+	if len(options.HMACKeys) > 0 {
+		keys, err := parsePairs(options.HMACKeys)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, corduroy.NewHMACAuthenticator(keys))
+	}
+	if options.CertificateAuth {
+		authenticators = append(authenticators, corduroy.NewCertificateAuthenticator())
+	}
+	var authorizer corduroy.Authorizer
+	if len(options.Rules) > 0 {
+		rules := make([]corduroy.PrefixRule, 0, len(options.Rules))
+		for _, r := range options.Rules {
+			parts := strings.SplitN(r, ":", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("access rule '%s' should be principal:prefix:rw", r)
+			}
+			rules = append(rules, corduroy.PrefixRule{
+				Principal: parts[0],
+				Prefix: parts[1],
+				Read: strings.Contains(parts[2], "r"),
+				Write: strings.Contains(parts[2], "w"),
+			})
+		}
+		authorizer = corduroy.NewPrefixAuthorizer(rules...)
+	}
+	if (len(authenticators) > 0 || authorizer != nil) && options.ClusterSecret == "" {
+		return fmt.Errorf("authentication and access rules require a cluster secret so peers can be told apart from clients")
+	}
+	node.UseAuthentication(authenticators...)
+	node.UseAuthorization(authorizer)
+	node.UseClusterSecret(options.ClusterSecret)
+	return nil
+}
+
+func parsePairs(values []string) (map[string]string, error) {
+	pairs := make(map[string]string, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("value '%s' should be name:secret", v)
+		}
+		pairs[parts[0]] = parts[1]
+	}
+	return pairs, nil
+}
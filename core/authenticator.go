@@ -0,0 +1,36 @@
+package corduroy
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+const principalAttribute = "principal"
+const peerPrincipal = "corduroy-peer"
+const anonymousPrincipal = ""
+
+var ErrNoCredentials = errors.New("no credentials supplied")
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+type Authenticator interface {
+	Authenticate(request *http.Request) (string, error)
+}
+
+func authenticate(authenticators []Authenticator, request *http.Request) (string, error) {
+	for _, authenticator := range authenticators {
+		principal, err := authenticator.Authenticate(request)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			return anonymousPrincipal, err
+		}
+		return principal, nil
+	}
+	return anonymousPrincipal, ErrNoCredentials
+}
+
+func secureEquals(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
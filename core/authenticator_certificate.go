@@ -0,0 +1,31 @@
+package corduroy
+
+import (
+	"net/http"
+)
+
+type CertificateAuthenticator struct {
+	allowed map[string]bool
+}
+
+func NewCertificateAuthenticator(allowed ...string) *CertificateAuthenticator {
+	a := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		a[name] = true
+	}
+	return &CertificateAuthenticator{
+		allowed: a,
+	}
+}
+
+func (ca *CertificateAuthenticator) Authenticate(request *http.Request) (string, error) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return anonymousPrincipal, ErrNoCredentials
+	}
+
+	name := request.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(ca.allowed) > 0 && !ca.allowed[name] {
+		return anonymousPrincipal, ErrInvalidCredentials
+	}
+	return name, nil
+}
@@ -0,0 +1,96 @@
+package corduroy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const hmacPrefix = "HMAC "
+const hmacDateHeader = "X-Corduroy-Date"
+const hmacMaxSkewSeconds = 300
+
+type HMACAuthenticator struct {
+	keys map[string][]byte
+}
+
+func NewHMACAuthenticator(keys map[string]string) *HMACAuthenticator {
+	k := make(map[string][]byte, len(keys))
+	for id, secret := range keys {
+		k[id] = []byte(secret)
+	}
+	return &HMACAuthenticator{
+		keys: k,
+	}
+}
+
+func (ha *HMACAuthenticator) Authenticate(request *http.Request) (string, error) {
+	authorization := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, hmacPrefix) {
+		return anonymousPrincipal, ErrNoCredentials
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(authorization, hmacPrefix), ":", 2)
+	if len(parts) != 2 {
+		return anonymousPrincipal, ErrInvalidCredentials
+	}
+	id := parts[0]
+	secret, ok := ha.keys[id]
+	if !ok {
+		return anonymousPrincipal, ErrInvalidCredentials
+	}
+
+	date, err := strconv.ParseInt(request.Header.Get(hmacDateHeader), 10, 64)
+	if err != nil {
+		return anonymousPrincipal, ErrInvalidCredentials
+	}
+	skew := time.Since(time.Unix(date, 0))
+	if skew > time.Second*hmacMaxSkewSeconds || skew < -time.Second*hmacMaxSkewSeconds {
+		return anonymousPrincipal, ErrInvalidCredentials
+	}
+
+	signature, err := signRequest(request, secret)
+	if err != nil {
+		return anonymousPrincipal, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(parts[1])) {
+		return anonymousPrincipal, ErrInvalidCredentials
+	}
+	return id, nil
+}
+
+func SignRequest(request *http.Request, id string, secret string) error {
+	request.Header.Set(hmacDateHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	signature, err := signRequest(request, []byte(secret))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", hmacPrefix+id+":"+signature)
+	return nil
+}
+
+func signRequest(request *http.Request, secret []byte) (string, error) {
+	body := []byte{}
+	if request.Body != nil {
+		b, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			return "", err
+		}
+		request.Body.Close()
+		request.Body = ioutil.NopCloser(bytes.NewReader(b))
+		body = b
+	}
+
+	digest := sha256.Sum256(body)
+	canonical := request.Method + "\n" + request.URL.RequestURI() + "\n" + request.Header.Get(hmacDateHeader) + "\n" + hex.EncodeToString(digest[:])
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,52 @@
+package corduroy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	authenticator := NewTokenAuthenticator(map[string]string{"alice": "secret"})
+	request, _ := http.NewRequest("GET", "http://localhost/entities/foo", nil)
+	_, err := authenticator.Authenticate(request)
+	assert.Equal(t, ErrNoCredentials, err)
+	request.Header.Set("Authorization", "Bearer wrong")
+	_, err = authenticator.Authenticate(request)
+	assert.Equal(t, ErrInvalidCredentials, err)
+	request.Header.Set("Authorization", "Bearer secret")
+	principal, err := authenticator.Authenticate(request)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", principal)
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	authenticator := NewHMACAuthenticator(map[string]string{"bob": "shared"})
+	request, _ := http.NewRequest("PUT", "http://localhost/entities/foo", bytes.NewBufferString("bar"))
+	err := SignRequest(request, "bob", "shared")
+	assert.NoError(t, err)
+	principal, err := authenticator.Authenticate(request)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", principal)
+
+	tampered, _ := http.NewRequest("PUT", "http://localhost/entities/foo", bytes.NewBufferString("baz"))
+	tampered.Header = request.Header
+	_, err = authenticator.Authenticate(tampered)
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	stale, _ := http.NewRequest("PUT", "http://localhost/entities/foo", bytes.NewBufferString("bar"))
+	SignRequest(stale, "bob", "shared")
+	stale.Header.Set(hmacDateHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	_, err = authenticator.Authenticate(stale)
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestCertificateAuthenticatorWithoutTLS(t *testing.T) {
+	authenticator := NewCertificateAuthenticator()
+	request, _ := http.NewRequest("GET", "http://localhost/entities/foo", nil)
+	_, err := authenticator.Authenticate(request)
+	assert.Equal(t, ErrNoCredentials, err)
+}
@@ -0,0 +1,41 @@
+package corduroy
+
+import (
+	"net/http"
+	"strings"
+)
+
+const bearerPrefix = "Bearer "
+
+type TokenAuthenticator struct {
+	tokens map[string]string
+}
+
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	t := make(map[string]string, len(tokens))
+	for principal, token := range tokens {
+		t[principal] = token
+	}
+	return &TokenAuthenticator{
+		tokens: t,
+	}
+}
+
+func (ta *TokenAuthenticator) Authenticate(request *http.Request) (string, error) {
+	authorization := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return anonymousPrincipal, ErrNoCredentials
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, bearerPrefix))
+	found := anonymousPrincipal
+	for principal, t := range ta.tokens {
+		if secureEquals(token, t) {
+			found = principal
+		}
+	}
+	if found == anonymousPrincipal {
+		return anonymousPrincipal, ErrInvalidCredentials
+	}
+	return found, nil
+}
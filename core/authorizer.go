@@ -0,0 +1,5 @@
+package corduroy
+
+type Authorizer interface {
+	Authorize(principal string, key string, write bool) bool
+}
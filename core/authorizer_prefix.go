@@ -0,0 +1,47 @@
+package corduroy
+
+import (
+	"strings"
+)
+
+const anyPrincipal = "*"
+
+type PrefixRule struct {
+	Principal string
+	Prefix    string
+	Read      bool
+	Write     bool
+}
+
+type PrefixAuthorizer struct {
+	rules []PrefixRule
+}
+
+func NewPrefixAuthorizer(rules ...PrefixRule) *PrefixAuthorizer {
+	return &PrefixAuthorizer{
+		rules: rules,
+	}
+}
+
+func (pa *PrefixAuthorizer) Authorize(principal string, key string, write bool) bool {
+	var best *PrefixRule
+	for i, rule := range pa.rules {
+		if rule.Principal != anyPrincipal && rule.Principal != principal {
+			continue
+		}
+		if !strings.HasPrefix(key, rule.Prefix) {
+			continue
+		}
+		if best == nil || len(rule.Prefix) > len(best.Prefix) || (len(rule.Prefix) == len(best.Prefix) && best.Principal == anyPrincipal) {
+			best = &pa.rules[i]
+		}
+	}
+
+	if best == nil {
+		return false
+	}
+	if write {
+		return best.Write
+	}
+	return best.Read
+}
@@ -0,0 +1,22 @@
+package corduroy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPrefixAuthorizer(t *testing.T) {
+	authorizer := NewPrefixAuthorizer(
+		PrefixRule{Principal: anyPrincipal, Prefix: "public/", Read: true},
+		PrefixRule{Principal: "alice", Prefix: "", Read: true},
+		PrefixRule{Principal: "alice", Prefix: "alice/", Read: true, Write: true},
+		PrefixRule{Principal: "alice", Prefix: "alice/locked/", Read: true},
+	)
+	assert.True(t, authorizer.Authorize("bob", "public/readme", false))
+	assert.False(t, authorizer.Authorize("bob", "public/readme", true))
+	assert.False(t, authorizer.Authorize("bob", "alice/notes", false))
+	assert.True(t, authorizer.Authorize("alice", "bob/notes", false))
+	assert.True(t, authorizer.Authorize("alice", "alice/notes", true))
+	assert.False(t, authorizer.Authorize("alice", "alice/locked/notes", true))
+	assert.False(t, authorizer.Authorize("alice", "bob/notes", true))
+}
@@ -0,0 +1,165 @@
+package corduroy
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const maxCacheReaderKeys = 65536
+
+type cachedEntry struct {
+	key   string
+	entry *Entry
+	until time.Time
+}
+
+type hotCache struct {
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	order    *list.List
+	mux      sync.Mutex
+}
+
+func newHotCache(capacity int, ttl time.Duration) *hotCache {
+	return &hotCache{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (hc *hotCache) configure(capacity int, ttl time.Duration) {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	hc.capacity = capacity
+	hc.ttl = ttl
+	hc.items = make(map[string]*list.Element)
+	hc.order.Init()
+}
+
+func (hc *hotCache) enabled() (time.Duration, bool) {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	return hc.ttl, hc.capacity > 0
+}
+
+func (hc *hotCache) get(key string, now time.Time) (*Entry, bool) {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	element, found := hc.items[key]
+	if !found {
+		return nil, false
+	}
+	cached := element.Value.(*cachedEntry)
+	if !now.Before(cached.until) {
+		hc.order.Remove(element)
+		delete(hc.items, key)
+		return nil, false
+	}
+	hc.order.MoveToFront(element)
+	return cached.entry, true
+}
+
+func (hc *hotCache) put(key string, entry *Entry, now time.Time) {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	if hc.capacity <= 0 {
+		return
+	}
+	until := now.Add(hc.ttl)
+	if !entry.Expiry.IsZero() && entry.Expiry.Before(until) {
+		until = entry.Expiry
+	}
+	if element, found := hc.items[key]; found {
+		element.Value = &cachedEntry{key: key, entry: entry, until: until}
+		hc.order.MoveToFront(element)
+		return
+	}
+	hc.items[key] = hc.order.PushFront(&cachedEntry{key: key, entry: entry, until: until})
+	for hc.order.Len() > hc.capacity {
+		oldest := hc.order.Back()
+		hc.order.Remove(oldest)
+		delete(hc.items, oldest.Value.(*cachedEntry).key)
+	}
+}
+
+func (hc *hotCache) remove(key string) bool {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	element, found := hc.items[key]
+	if !found {
+		return false
+	}
+	hc.order.Remove(element)
+	delete(hc.items, key)
+	return true
+}
+
+func (hc *hotCache) size() int {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	return hc.order.Len()
+}
+
+type cacheReaders struct {
+	keys map[string]map[int]time.Time
+	mux  sync.Mutex
+}
+
+func newCacheReaders() *cacheReaders {
+	return &cacheReaders{keys: make(map[string]map[int]time.Time)}
+}
+
+func (cr *cacheReaders) record(key string, ids []int, until time.Time) {
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	readers, found := cr.keys[key]
+	if !found {
+		if len(cr.keys) >= maxCacheReaderKeys {
+			cr.prune(time.Now())
+		}
+		if len(cr.keys) >= maxCacheReaderKeys {
+			return
+		}
+		readers = make(map[int]time.Time)
+		cr.keys[key] = readers
+	}
+	for _, id := range ids {
+		if until.After(readers[id]) {
+			readers[id] = until
+		}
+	}
+}
+
+func (cr *cacheReaders) take(key string, now time.Time) []int {
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	readers, found := cr.keys[key]
+	if !found {
+		return nil
+	}
+	delete(cr.keys, key)
+	ids := make([]int, 0, len(readers))
+	for id, until := range readers {
+		if now.Before(until) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (cr *cacheReaders) prune(now time.Time) {
+	for key, readers := range cr.keys {
+		for id, until := range readers {
+			if !now.Before(until) {
+				delete(readers, id)
+			}
+		}
+		if len(readers) == 0 {
+			delete(cr.keys, key)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package corduroy
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHotCache(t *testing.T) {
+	now := time.Now()
+	cache := newHotCache(2, time.Minute)
+	cache.put("a", &Entry{Value: []byte("1")}, now)
+	cache.put("b", &Entry{Value: []byte("2")}, now)
+	_, found := cache.get("a", now)
+	assert.True(t, found)
+
+	cache.put("c", &Entry{Value: []byte("3")}, now)
+	assert.Equal(t, 2, cache.size())
+	_, found = cache.get("b", now)
+	assert.False(t, found)
+	entry, found := cache.get("a", now)
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), entry.Value)
+
+	_, found = cache.get("a", now.Add(time.Minute))
+	assert.False(t, found)
+	cache.put("d", &Entry{Value: []byte("4"), Expiry: now.Add(time.Second)}, now)
+	_, found = cache.get("d", now.Add(time.Second*2))
+	assert.False(t, found)
+
+	assert.True(t, cache.remove("c"))
+	assert.False(t, cache.remove("c"))
+	cache.configure(0, time.Minute)
+	cache.put("e", &Entry{Value: []byte("5")}, now)
+	assert.Equal(t, 0, cache.size())
+}
+
+func TestCacheReaders(t *testing.T) {
+	now := time.Now()
+	readers := newCacheReaders()
+	readers.record("a", []int{1, 2}, now.Add(time.Second))
+	readers.record("a", []int{3}, now.Add(-time.Second))
+	assert.ElementsMatch(t, []int{1, 2}, readers.take("a", now))
+	assert.Empty(t, readers.take("a", now))
+}
+
+func TestClusterHotKeyCache(t *testing.T) {
+	owner := createTestNode()
+	reader := createTestNode()
+	err := reader.Connect(owner.Address)
+	assert.NoError(t, err)
+	reader.UseCache(&CacheOptions{Size: 16, TTL: time.Minute})
+
+	key := "hot"
+	owner.putEntry(key, &Entry{Value: []byte("first"), Version: 1}, ClientOrigin)
+	for i := 0; i < 3; i++ {
+		statusCode, body, err := reader.getValueRemote(context.Background(), reader.Address, key, []int{}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "first", body)
+	}
+	assert.Equal(t, float64(1), reader.metrics.cacheRequests.get(cacheMiss))
+	assert.Equal(t, float64(2), reader.metrics.cacheRequests.get(cacheHit))
+	assert.Equal(t, float64(1), owner.metrics.requests.get(entitiesPath+"/{"+keyPath+"}", "GET", "200"))
+
+	statusCode, _ := sendTestHeaders(t, "GET", reader.Address+entityPath(key), "", map[string]string{hopsHeader: "1", "If-None-Match": formatETag(1)})
+	assert.Equal(t, http.StatusNotModified, statusCode)
+
+	owner.putEntry(key, &Entry{Value: []byte("second"), Version: 2}, ClientOrigin)
+	for i := 0; i < 100 && reader.cache.size() > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 0, reader.cache.size())
+	assert.Equal(t, float64(1), reader.metrics.cacheInvalidations.get())
+	_, body, err := reader.getValueRemote(context.Background(), reader.Address, key, []int{}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", body)
+}
@@ -0,0 +1,245 @@
+package corduroy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const ClientOrigin = "client"
+const ReplicationOrigin = "replication"
+const SystemOrigin = "system"
+
+const defaultChangeRetentionEntries = 100000
+
+var ErrChangesCompacted = errors.New("requested sequence is no longer retained")
+
+type Change struct {
+	Sequence  uint64     `json:"sequence"`
+	Time      time.Time  `json:"time"`
+	Origin    string     `json:"origin"`
+	Type      string     `json:"type"`
+	Namespace string     `json:"namespace,omitempty"`
+	Key       string     `json:"key"`
+	Value     []byte     `json:"-"`
+	Size      int64      `json:"size,omitempty"`
+	Version   uint64     `json:"version,omitempty"`
+	Expiry    *time.Time `json:"expiry,omitempty"`
+}
+
+type ChangeRetention struct {
+	MaxEntries int
+	MaxAge     time.Duration
+}
+
+type changeLog struct {
+	path      string
+	file      *os.File
+	retention ChangeRetention
+	sequence  uint64
+	changes   []*Change
+	stale     int
+	appended  chan struct{}
+	logger    Logger
+	mux       sync.Mutex
+}
+
+func newChangeLog(retention ChangeRetention) *changeLog {
+	if retention.MaxEntries <= 0 && retention.MaxAge <= 0 {
+		retention.MaxEntries = defaultChangeRetentionEntries
+	}
+	return &changeLog{
+		retention: retention,
+		changes:   make([]*Change, 0),
+		appended:  make(chan struct{}),
+		logger:    defaultLogger(),
+	}
+}
+
+func openChangeLog(path string, retention ChangeRetention, logger Logger) (*changeLog, error) {
+	cl := newChangeLog(retention)
+	cl.path = path
+	cl.logger = logger
+	f, err := os.Open(path)
+	if err == nil {
+		err = cl.load(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	cl.trim(time.Now())
+	err = cl.rewrite()
+	if err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// load reads every change in r. Only the last line may fail to parse, since a
+// crash can leave a torn write there; anything earlier is reported as corruption.
+func (cl *changeLog) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	torn := 0
+	for scanner.Scan() {
+		line++
+		if torn > 0 {
+			return fmt.Errorf("change log '%s' is corrupt at line %d", cl.path, torn)
+		}
+		change := &Change{}
+		if json.Unmarshal(scanner.Bytes(), change) != nil {
+			torn = line
+			continue
+		}
+		cl.changes = append(cl.changes, change)
+		cl.sequence = change.Sequence
+	}
+	err := scanner.Err()
+	if err != nil {
+		return err
+	}
+	if torn > 0 {
+		cl.logger.Warn("dropped torn change at the end of the change log", F("path", cl.path), F("line", torn))
+	}
+	return nil
+}
+
+func (cl *changeLog) append(origin string, kind string, key string, entry *Entry) *Change {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	cl.sequence++
+	ns, k := splitNamespaceKey(key)
+	change := &Change{Sequence: cl.sequence, Time: time.Now().UTC(), Origin: origin, Type: kind, Namespace: ns, Key: k}
+	if entry != nil {
+		change.Value, change.Size = listedValue(entry.Value)
+		change.Version = entry.Version
+		if !entry.Expiry.IsZero() {
+			expiry := entry.Expiry
+			change.Expiry = &expiry
+		}
+	}
+	cl.changes = append(cl.changes, change)
+
+	if cl.file != nil {
+		b, _ := json.Marshal(change)
+		_, err := cl.file.Write(append(b, '\n'))
+		if err == nil {
+			err = cl.file.Sync()
+		}
+		if err != nil {
+			cl.logger.Error("unable to write change", F("sequence", change.Sequence), F("path", cl.path), F(errorField, err))
+		}
+	}
+
+	cl.stale += cl.trim(change.Time)
+	if cl.file != nil && cl.stale > 0 && cl.stale >= len(cl.changes) {
+		err := cl.rewrite()
+		if err != nil {
+			cl.logger.Warn("unable to compact change log", F("path", cl.path), F(errorField, err))
+		}
+	}
+
+	close(cl.appended)
+	cl.appended = make(chan struct{})
+	return change
+}
+
+func (cl *changeLog) trim(now time.Time) int {
+	drop := 0
+	if cl.retention.MaxEntries > 0 && len(cl.changes) > cl.retention.MaxEntries {
+		drop = len(cl.changes) - cl.retention.MaxEntries
+	}
+	if cl.retention.MaxAge > 0 {
+		for drop < len(cl.changes)-1 && now.Sub(cl.changes[drop].Time) > cl.retention.MaxAge {
+			drop++
+		}
+	}
+	if drop > 0 {
+		cl.changes = append(make([]*Change, 0, len(cl.changes)-drop), cl.changes[drop:]...)
+	}
+	return drop
+}
+
+func (cl *changeLog) rewrite() error {
+	if cl.path == "" {
+		return nil
+	}
+	temp := cl.path + ".tmp"
+	f, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, change := range cl.changes {
+		b, _ := json.Marshal(change)
+		w.Write(append(b, '\n'))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp, cl.path)
+	if err != nil {
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(cl.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	if cl.file != nil {
+		cl.file.Close()
+	}
+	cl.stale = 0
+	cl.file, err = os.OpenFile(cl.path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+func (cl *changeLog) read(since uint64, limit int) ([]*Change, <-chan struct{}, error) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	if len(cl.changes) > 0 && cl.changes[0].Sequence > since+1 {
+		return nil, nil, ErrChangesCompacted
+	}
+	if len(cl.changes) == 0 && since < cl.sequence {
+		return nil, nil, ErrChangesCompacted
+	}
+
+	start := len(cl.changes)
+	if len(cl.changes) > 0 && since < cl.sequence {
+		start = int(since + 1 - cl.changes[0].Sequence)
+	}
+	end := len(cl.changes)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	changes := make([]*Change, end-start)
+	copy(changes, cl.changes[start:end])
+	return changes, cl.appended, nil
+}
+
+func (cl *changeLog) close() error {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	if cl.file == nil {
+		return nil
+	}
+	err := cl.file.Close()
+	cl.file = nil
+	return err
+}
@@ -0,0 +1,99 @@
+package corduroy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangeLogReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "corduroy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "changes.log")
+
+	cl, err := openChangeLog(path, ChangeRetention{}, defaultLogger())
+	assert.NoError(t, err)
+	cl.append(ClientOrigin, PutEvent, "foo", &Entry{Value: []byte("bar"), Version: 1})
+	cl.append(ReplicationOrigin, DeleteEvent, namespaceKey("sessions", "abc"), nil)
+	assert.NoError(t, cl.close())
+
+	cl, err = openChangeLog(path, ChangeRetention{}, defaultLogger())
+	assert.NoError(t, err)
+	changes, _, err := cl.read(0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, "bar", string(changes[0].Value))
+	assert.Equal(t, "sessions", changes[1].Namespace)
+	assert.Equal(t, "abc", changes[1].Key)
+	assert.Equal(t, ReplicationOrigin, changes[1].Origin)
+
+	change := cl.append(ClientOrigin, PutEvent, "baz", &Entry{Value: []byte("qux")})
+	assert.Equal(t, uint64(3), change.Sequence)
+	assert.NoError(t, cl.close())
+}
+
+func TestChangeLogRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "corduroy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "changes.log")
+
+	cl, err := openChangeLog(path, ChangeRetention{MaxEntries: 3}, defaultLogger())
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		cl.append(ClientOrigin, PutEvent, "foo", &Entry{Value: []byte("bar")})
+	}
+	_, _, err = cl.read(2, 0)
+	assert.Equal(t, ErrChangesCompacted, err)
+	changes, _, err := cl.read(7, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, uint64(8), changes[0].Sequence)
+	changes, _, err = cl.read(7, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.NoError(t, cl.close())
+
+	cl, err = openChangeLog(path, ChangeRetention{MaxAge: time.Millisecond}, defaultLogger())
+	assert.NoError(t, err)
+	changes, _, err = cl.read(9, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, uint64(10), changes[0].Sequence)
+	assert.NoError(t, cl.close())
+}
+
+func TestChangeLogCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "corduroy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "changes.log")
+
+	cl, err := openChangeLog(path, ChangeRetention{}, defaultLogger())
+	assert.NoError(t, err)
+	cl.append(ClientOrigin, PutEvent, "foo", &Entry{Value: []byte("bar"), Version: 1})
+	cl.append(ClientOrigin, PutEvent, "baz", &Entry{Value: []byte("qux"), Version: 2})
+	assert.NoError(t, cl.close())
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(path, append(append([]byte{}, b...), []byte(`{"sequence": 3, "ti`)...), 0644))
+	cl, err = openChangeLog(path, ChangeRetention{}, defaultLogger())
+	assert.NoError(t, err)
+	changes, _, err := cl.read(0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(changes))
+	assert.NoError(t, cl.close())
+
+	corrupt := append([]byte("not json\n"), b...)
+	assert.NoError(t, ioutil.WriteFile(path, corrupt, 0644))
+	_, err = openChangeLog(path, ChangeRetention{}, defaultLogger())
+	assert.Error(t, err)
+	after, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, corrupt, after)
+}
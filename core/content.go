@@ -0,0 +1,177 @@
+package corduroy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const anyMime = "*/*"
+const contentTypeHeader = "Content-Type"
+const metaHeaderPrefix = "X-Corduroy-Meta-"
+const base64Encoding = "base64"
+
+func readContentHeaders(header http.Header) (string, map[string]string) {
+	var meta map[string]string
+	for name, values := range header {
+		if strings.HasPrefix(name, metaHeaderPrefix) && len(name) > len(metaHeaderPrefix) && len(values) > 0 {
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			meta[strings.TrimPrefix(name, metaHeaderPrefix)] = values[0]
+		}
+	}
+	return header.Get(contentTypeHeader), meta
+}
+
+func setContentHeaders(header http.Header, entry *Entry) {
+	header.Del(contentTypeHeader)
+	if entry.ContentType != "" {
+		header.Set(contentTypeHeader, entry.ContentType)
+	}
+	for name, value := range entry.Meta {
+		header.Set(metaHeaderPrefix+name, value)
+	}
+}
+
+func writeContentHeaders(header http.Header, entry *Entry) {
+	setContentHeaders(header, entry)
+	if entry.ContentType == "" {
+		header[contentTypeHeader] = nil
+	}
+}
+
+func copyContentHeaders(to http.Header, from http.Header) {
+	contentType, meta := readContentHeaders(from)
+	writeContentHeaders(to, &Entry{ContentType: contentType, Meta: meta})
+}
+
+type encodedValue struct {
+	Value    string `json:"value,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func encodeValue(value []byte) encodedValue {
+	if utf8.Valid(value) {
+		return encodedValue{Value: string(value)}
+	}
+	return encodedValue{Value: base64.StdEncoding.EncodeToString(value), Encoding: base64Encoding}
+}
+
+func (ev encodedValue) decode() ([]byte, error) {
+	switch ev.Encoding {
+	case "":
+		return []byte(ev.Value), nil
+	case base64Encoding:
+		return base64.StdEncoding.DecodeString(ev.Value)
+	default:
+		return nil, &json.UnsupportedValueError{Str: ev.Encoding}
+	}
+}
+
+// marshalValue encodes v, whose own value field is excluded from JSON, and
+// appends value as text when it is valid UTF-8 and as base64 otherwise.
+func marshalValue(v interface{}, value []byte) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	tail, err := json.Marshal(encodeValue(value))
+	if err != nil {
+		return nil, err
+	}
+	if len(tail) <= len("{}") {
+		return b, nil
+	}
+	if len(b) <= len("{}") {
+		return tail, nil
+	}
+	return append(append(b[:len(b)-1], ','), tail[1:]...), nil
+}
+
+func unmarshalValue(b []byte, v interface{}) ([]byte, error) {
+	err := json.Unmarshal(b, v)
+	if err != nil {
+		return nil, err
+	}
+	ev := encodedValue{}
+	err = json.Unmarshal(b, &ev)
+	if err != nil {
+		return nil, err
+	}
+	return ev.decode()
+}
+
+func (item batchItem) MarshalJSON() ([]byte, error) {
+	type plain batchItem
+	return marshalValue(plain(item), item.Value)
+}
+
+func (item *batchItem) UnmarshalJSON(b []byte) error {
+	type plain batchItem
+	var err error
+	item.Value, err = unmarshalValue(b, (*plain)(item))
+	return err
+}
+
+func (result BatchResult) MarshalJSON() ([]byte, error) {
+	type plain BatchResult
+	return marshalValue(plain(result), result.Value)
+}
+
+func (result *BatchResult) UnmarshalJSON(b []byte) error {
+	type plain BatchResult
+	var err error
+	result.Value, err = unmarshalValue(b, (*plain)(result))
+	return err
+}
+
+func (item handoffItem) MarshalJSON() ([]byte, error) {
+	type plain handoffItem
+	return marshalValue(plain(item), item.Value)
+}
+
+func (item *handoffItem) UnmarshalJSON(b []byte) error {
+	type plain handoffItem
+	var err error
+	item.Value, err = unmarshalValue(b, (*plain)(item))
+	return err
+}
+
+func (item RangeItem) MarshalJSON() ([]byte, error) {
+	type plain RangeItem
+	return marshalValue(plain(item), item.Value)
+}
+
+func (item *RangeItem) UnmarshalJSON(b []byte) error {
+	type plain RangeItem
+	var err error
+	item.Value, err = unmarshalValue(b, (*plain)(item))
+	return err
+}
+
+func (event Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	return marshalValue(plain(event), event.Value)
+}
+
+func (event *Event) UnmarshalJSON(b []byte) error {
+	type plain Event
+	var err error
+	event.Value, err = unmarshalValue(b, (*plain)(event))
+	return err
+}
+
+func (change Change) MarshalJSON() ([]byte, error) {
+	type plain Change
+	return marshalValue(plain(change), change.Value)
+}
+
+func (change *Change) UnmarshalJSON(b []byte) error {
+	type plain Change
+	var err error
+	change.Value, err = unmarshalValue(b, (*plain)(change))
+	return err
+}
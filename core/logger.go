@@ -0,0 +1,191 @@
+package corduroy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+const nodeIDField = "node_id"
+const keyField = "key"
+const peerField = "peer"
+const hopsField = "hops"
+const errorField = "error"
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(s, "warning") {
+		return WarnLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level '%s'", s)
+}
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func defaultLogger() Logger {
+	return NewTextLogger(os.Stderr, InfoLevel)
+}
+
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	With(fields ...Field) Logger
+}
+
+type LevelSetter interface {
+	SetLevel(level Level)
+}
+
+type streamLogger struct {
+	out    io.Writer
+	level  *int32
+	json   bool
+	fields []Field
+	mux    *sync.Mutex
+}
+
+func NewTextLogger(out io.Writer, level Level) Logger {
+	return &streamLogger{out: out, level: newLevel(level), mux: &sync.Mutex{}}
+}
+
+func NewJSONLogger(out io.Writer, level Level) Logger {
+	return &streamLogger{out: out, level: newLevel(level), json: true, mux: &sync.Mutex{}}
+}
+
+func newLevel(level Level) *int32 {
+	l := int32(level)
+	return &l
+}
+
+func (sl *streamLogger) SetLevel(level Level) {
+	atomic.StoreInt32(sl.level, int32(level))
+}
+
+func (sl *streamLogger) Debug(msg string, fields ...Field) {
+	sl.write(DebugLevel, msg, fields)
+}
+
+func (sl *streamLogger) Info(msg string, fields ...Field) {
+	sl.write(InfoLevel, msg, fields)
+}
+
+func (sl *streamLogger) Warn(msg string, fields ...Field) {
+	sl.write(WarnLevel, msg, fields)
+}
+
+func (sl *streamLogger) Error(msg string, fields ...Field) {
+	sl.write(ErrorLevel, msg, fields)
+}
+
+func (sl *streamLogger) With(fields ...Field) Logger {
+	combined := make([]Field, 0, len(sl.fields)+len(fields))
+	combined = append(combined, sl.fields...)
+	combined = append(combined, fields...)
+	return &streamLogger{out: sl.out, level: sl.level, json: sl.json, fields: combined, mux: sl.mux}
+}
+
+func (sl *streamLogger) write(level Level, msg string, fields []Field) {
+	if level < Level(atomic.LoadInt32(sl.level)) {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	all := append(append(make([]Field, 0, len(sl.fields)+len(fields)), sl.fields...), fields...)
+
+	var line []byte
+	if sl.json {
+		line = formatJSONLine(now, level, msg, all)
+	} else {
+		line = formatTextLine(now, level, msg, all)
+	}
+
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	sl.out.Write(line)
+}
+
+func formatJSONLine(now string, level Level, msg string, fields []Field) []byte {
+	b := []byte("{")
+	b = appendJSONPair(b, "time", now)
+	b = append(b, ',')
+	b = appendJSONPair(b, "level", level.String())
+	b = append(b, ',')
+	b = appendJSONPair(b, "msg", msg)
+	for _, f := range fields {
+		b = append(b, ',')
+		b = appendJSONPair(b, f.Key, fieldValue(f.Value))
+	}
+	return append(b, "}\n"...)
+}
+
+func appendJSONPair(b []byte, key string, value interface{}) []byte {
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b = append(b, k...)
+	b = append(b, ':')
+	return append(b, v...)
+}
+
+func formatTextLine(now string, level Level, msg string, fields []Field) []byte {
+	b := []byte("time=" + now + " level=" + level.String() + " msg=" + quoteText(msg))
+	for _, f := range fields {
+		b = append(b, ' ')
+		b = append(b, f.Key...)
+		b = append(b, '=')
+		b = append(b, quoteText(fmt.Sprint(fieldValue(f.Value)))...)
+	}
+	return append(b, '\n')
+}
+
+func fieldValue(value interface{}) interface{} {
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+	return value
+}
+
+func quoteText(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
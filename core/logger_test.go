@@ -0,0 +1,49 @@
+package corduroy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestTextLogger(t *testing.T) {
+	b := &bytes.Buffer{}
+	logger := NewTextLogger(b, InfoLevel).With(F(nodeIDField, 42))
+	logger.Debug("hidden")
+	logger.Info("wrote value", F(keyField, "foo bar"), F(hopsField, 2))
+	logger.Error("failed", F(errorField, errors.New("boom")))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], `level=info msg="wrote value" node_id=42 key="foo bar" hops=2`)
+	assert.Contains(t, lines[1], `level=error msg=failed node_id=42 error=boom`)
+}
+
+func TestJSONLogger(t *testing.T) {
+	b := &bytes.Buffer{}
+	logger := NewJSONLogger(b, WarnLevel).With(F(nodeIDField, 42))
+	logger.Info("hidden")
+	logger.Warn("unable to sync", F(peerField, "http://localhost:8080/"), F(errorField, errors.New("refused")))
+
+	line := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &line))
+	assert.Equal(t, "warn", line["level"])
+	assert.Equal(t, "unable to sync", line["msg"])
+	assert.Equal(t, float64(42), line[nodeIDField])
+	assert.Equal(t, "http://localhost:8080/", line[peerField])
+	assert.Equal(t, "refused", line[errorField])
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("DEBUG")
+	assert.NoError(t, err)
+	assert.Equal(t, DebugLevel, level)
+	level, err = ParseLevel("warning")
+	assert.NoError(t, err)
+	assert.Equal(t, WarnLevel, level)
+	_, err = ParseLevel("loud")
+	assert.Error(t, err)
+}
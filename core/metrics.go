@@ -0,0 +1,221 @@
+package corduroy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const CounterMetric = "counter"
+const GaugeMetric = "gauge"
+const HistogramMetric = "histogram"
+
+const metricsMime = "text/plain; version=0.0.4; charset=utf-8"
+const labelSeparator = "\xff"
+
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+var hopBuckets = []float64{1, 2, 3, 4, 5, 8}
+
+type MetricSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+type MetricFamily struct {
+	Name    string         `json:"name"`
+	Help    string         `json:"help"`
+	Type    string         `json:"type"`
+	Samples []MetricSample `json:"samples"`
+}
+
+type metricCollector interface {
+	collect() *MetricFamily
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+	mux    sync.Mutex
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (cv *counterVec) add(v float64, labelValues ...string) {
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	cv.values[strings.Join(labelValues, labelSeparator)] += v
+}
+
+func (cv *counterVec) get(labelValues ...string) float64 {
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	return cv.values[strings.Join(labelValues, labelSeparator)]
+}
+
+func (cv *counterVec) collect() *MetricFamily {
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	family := &MetricFamily{Name: cv.name, Help: cv.help, Type: CounterMetric, Samples: make([]MetricSample, 0, len(cv.values))}
+	for _, key := range sortedLabelKeys(cv.values) {
+		family.Samples = append(family.Samples, MetricSample{Name: cv.name, Labels: buildLabels(cv.labels, key), Value: cv.values[key]})
+	}
+	return family
+}
+
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+func newGaugeFunc(name string, help string, value func() float64) *gaugeFunc {
+	return &gaugeFunc{name: name, help: help, value: value}
+}
+
+func (gf *gaugeFunc) collect() *MetricFamily {
+	return &MetricFamily{Name: gf.name, Help: gf.help, Type: GaugeMetric, Samples: []MetricSample{{Name: gf.name, Value: gf.value()}}}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+	mux     sync.Mutex
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (hv *histogramVec) observe(v float64, labelValues ...string) {
+	hv.mux.Lock()
+	defer hv.mux.Unlock()
+	key := strings.Join(labelValues, labelSeparator)
+	h, found := hv.values[key]
+	if !found {
+		h = &histogram{counts: make([]uint64, len(hv.buckets))}
+		hv.values[key] = h
+	}
+	for i, bound := range hv.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (hv *histogramVec) collect() *MetricFamily {
+	hv.mux.Lock()
+	defer hv.mux.Unlock()
+	family := &MetricFamily{Name: hv.name, Help: hv.help, Type: HistogramMetric, Samples: make([]MetricSample, 0)}
+	for _, key := range sortedLabelKeys(hv.values) {
+		h := hv.values[key]
+		for i, bound := range hv.buckets {
+			labels := buildLabels(hv.labels, key)
+			labels["le"] = formatMetricValue(bound)
+			family.Samples = append(family.Samples, MetricSample{Name: hv.name + "_bucket", Labels: labels, Value: float64(h.counts[i])})
+		}
+		labels := buildLabels(hv.labels, key)
+		labels["le"] = "+Inf"
+		family.Samples = append(family.Samples, MetricSample{Name: hv.name + "_bucket", Labels: labels, Value: float64(h.count)})
+		family.Samples = append(family.Samples, MetricSample{Name: hv.name + "_sum", Labels: buildLabels(hv.labels, key), Value: h.sum})
+		family.Samples = append(family.Samples, MetricSample{Name: hv.name + "_count", Labels: buildLabels(hv.labels, key), Value: float64(h.count)})
+	}
+	return family
+}
+
+func sortedLabelKeys(values interface{}) []string {
+	keys := make([]string, 0)
+	switch v := values.(type) {
+	case map[string]float64:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]*histogram:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func buildLabels(names []string, key string) map[string]string {
+	labels := make(map[string]string, len(names)+1)
+	if len(names) == 0 {
+		return labels
+	}
+	values := strings.Split(key, labelSeparator)
+	for i, name := range names {
+		if i < len(values) {
+			labels[name] = values[i]
+		}
+	}
+	return labels
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "le" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, found := labels["le"]; found {
+		names = append(names, "le")
+	}
+
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"=\""+replacer.Replace(labels[name])+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func writeMetrics(w io.Writer, families []*MetricFamily) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", family.Name, strings.Replace(family.Help, "\n", " ", -1))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			fmt.Fprintf(bw, "%s%s %s\n", sample.Name, formatLabels(sample.Labels), formatMetricValue(sample.Value))
+		}
+	}
+	return bw.Flush()
+}
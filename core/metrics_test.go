@@ -0,0 +1,38 @@
+package corduroy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	counter := newCounterVec("test_requests_total", "Requests.", "route", "code")
+	counter.add(1, "/a", "200")
+	counter.add(2, "/a", "200")
+	counter.add(1, "/b\"", "500")
+	histogram := newHistogramVec("test_seconds", "Latency.", []float64{0.1, 1})
+	histogram.observe(0.05)
+	histogram.observe(0.5)
+	histogram.observe(5)
+	gauge := newGaugeFunc("test_keys", "Keys.", func() float64 { return 7 })
+
+	b := &bytes.Buffer{}
+	err := writeMetrics(b, []*MetricFamily{counter.collect(), histogram.collect(), gauge.collect()})
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200",route="/a"} 3
+test_requests_total{code="500",route="/b\""} 1
+# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 5.55
+test_seconds_count 3
+# HELP test_keys Keys.
+# TYPE test_keys gauge
+test_keys 7
+`, b.String())
+}
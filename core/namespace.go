@@ -0,0 +1,153 @@
+package corduroy
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultNamespace = ""
+const namespaceSeparator = "\x1f"
+
+var ErrReservedKey = errors.New("keys may not contain the namespace separator")
+
+var namespaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+type Namespace struct {
+	Name          string `json:"name"`
+	Replicas      int    `json:"replicas"`
+	MaxValueBytes int    `json:"maxValueBytes"`
+	MaxKeys       int    `json:"maxKeys"`
+	MaxBytes      int64  `json:"maxBytes"`
+	Compress      bool   `json:"compress,omitempty"`
+	Revision      int64  `json:"revision"`
+	Dropped       bool   `json:"dropped,omitempty"`
+}
+
+type NamespaceUsage struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+type namespaceSnapshot struct {
+	Namespaces []*Namespace              `json:"namespaces"`
+	Usage      map[string]NamespaceUsage `json:"usage"`
+}
+
+func validateNamespace(ns *Namespace) error {
+	if !namespaceNamePattern.MatchString(ns.Name) {
+		return errors.New("namespace names must be alphanumeric with '.', '_' or '-' and at most 63 characters")
+	}
+	if ns.Replicas < 0 || ns.MaxValueBytes < 0 || ns.MaxKeys < 0 || ns.MaxBytes < 0 {
+		return errors.New("namespace limits must not be negative")
+	}
+	return nil
+}
+
+func validateKey(key string) error {
+	if strings.Contains(key, namespaceSeparator) {
+		return ErrReservedKey
+	}
+	return nil
+}
+
+func namespaceKey(ns string, key string) string {
+	if ns == defaultNamespace {
+		return key
+	}
+	return ns + namespaceSeparator + key
+}
+
+func splitNamespaceKey(key string) (string, string) {
+	i := strings.Index(key, namespaceSeparator)
+	if i < 0 {
+		return defaultNamespace, key
+	}
+	return key[:i], key[i+len(namespaceSeparator):]
+}
+
+type namespaceCatalog struct {
+	namespaces map[string]*Namespace
+	peerUsage  map[int]map[string]NamespaceUsage
+	mux        sync.RWMutex
+}
+
+func newNamespaceCatalog() *namespaceCatalog {
+	return &namespaceCatalog{
+		namespaces: make(map[string]*Namespace),
+		peerUsage:  make(map[int]map[string]NamespaceUsage),
+	}
+}
+
+func (nc *namespaceCatalog) merge(ns *Namespace) bool {
+	nc.mux.Lock()
+	defer nc.mux.Unlock()
+	existing, found := nc.namespaces[ns.Name]
+	if found && existing.Revision >= ns.Revision {
+		return false
+	}
+	n := *ns
+	nc.namespaces[ns.Name] = &n
+	return true
+}
+
+func (nc *namespaceCatalog) get(name string) (*Namespace, bool) {
+	nc.mux.RLock()
+	defer nc.mux.RUnlock()
+	ns, found := nc.namespaces[name]
+	if !found || ns.Dropped {
+		return nil, false
+	}
+	n := *ns
+	return &n, true
+}
+
+func (nc *namespaceCatalog) all() []*Namespace {
+	nc.mux.RLock()
+	defer nc.mux.RUnlock()
+	namespaces := make([]*Namespace, 0, len(nc.namespaces))
+	for _, ns := range nc.namespaces {
+		n := *ns
+		namespaces = append(namespaces, &n)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces
+}
+
+func (nc *namespaceCatalog) live() []*Namespace {
+	namespaces := make([]*Namespace, 0)
+	for _, ns := range nc.all() {
+		if !ns.Dropped {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+func (nc *namespaceCatalog) setPeerUsage(id int, usage map[string]NamespaceUsage) {
+	nc.mux.Lock()
+	defer nc.mux.Unlock()
+	nc.peerUsage[id] = usage
+}
+
+func (nc *namespaceCatalog) removePeer(id int) {
+	nc.mux.Lock()
+	defer nc.mux.Unlock()
+	delete(nc.peerUsage, id)
+}
+
+func (nc *namespaceCatalog) peerTotal(name string) NamespaceUsage {
+	nc.mux.RLock()
+	defer nc.mux.RUnlock()
+	total := NamespaceUsage{}
+	for _, usage := range nc.peerUsage {
+		u := usage[name]
+		total.Keys += u.Keys
+		total.Bytes += u.Bytes
+	}
+	return total
+}
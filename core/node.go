@@ -1,21 +1,31 @@
 package corduroy
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/emicklei/go-restful"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const redundantCopies = 3
 const syncFrequencySeconds = 20
+const rejoinFrequencySeconds = 5
+const expiryFrequencySeconds = 1
+const tombstoneRetentionMinutes = 60
+const connectInitialBackoffMilliseconds = 100
+const connectMaxBackoffSeconds = 30
 
 const keyPath = "key"
+const namespaceParam = "namespace"
 const idParam = "id"
 const addressParam = "address"
 
@@ -23,18 +33,60 @@ const pingPath = "/ping"
 const entitiesPath = "/entities"
 const nodesPath = "/nodes"
 const registerPath = "/register"
+const namespacesPath = "/namespaces"
 
 const visitedHeader = "X-Corduroy-Visited"
 const hopsHeader = "X-Corduroy-Hops"
+const clusterSecretHeader = "X-Corduroy-Cluster-Secret"
+const ttlHeader = "X-Corduroy-TTL"
+const expiresHeader = "X-Corduroy-Expires"
+const versionHeader = "X-Corduroy-Version"
+const nodeIDHeader = "X-Corduroy-Node"
+const syncHeader = "X-Corduroy-Sync"
+
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+var errSelfSeed = errors.New("seed address belongs to this node")
 
 type Node struct {
 	Address  string
 	ID       int
 	server   *http.Server
+	client   *http.Client
+	peerOptions *PeerClientOptions
+	wire        *wireTransport
+	protocols   []int
 	service  *restful.WebService
 	store    Store
 	registry Registry
+	namespaces *namespaceCatalog
+	watches    *watchHub
+	changes    *changeLog
+	metrics    *nodeMetrics
+	logger     Logger
+	tracer     *tracer
+	peers      *peerTracker
+	cache      *hotCache
+	readers    *cacheReaders
+	flights    *flightGroup
+	tombstones *tombstones
+	coalesceStore bool
+	chunkSize     int
+	maxObjectSize int64
+	compressAll     bool
+	compressMinSize int
+	state      *nodeState
+	started    time.Time
 	tickers  []*time.Ticker
+	seeds    []string
+	seedsMux sync.Mutex
+	writeMux sync.Mutex
+	done     chan struct{}
+
+	authenticators []Authenticator
+	authorizer     Authorizer
+	clusterSecret  string
+	authMux        sync.RWMutex
 }
 
 func NewNode(port int, path string, store Store, registry Registry) *Node {
@@ -43,27 +95,145 @@ func NewNode(port int, path string, store Store, registry Registry) *Node {
 		Address: "http://" + buildLocalUri(port) + path,
 		ID:      hash(address),
 		server:  &http.Server{Addr: ":" + strconv.Itoa(port)},
+		client:  newPeerClient(defaultPeerClientOptions()),
+		peerOptions: defaultPeerClientOptions(),
+		protocols:   supportedProtocols,
 		store:   store,
 		registry:   registry,
+		namespaces: newNamespaceCatalog(),
+		changes:    newChangeLog(ChangeRetention{}),
+		metrics:    newNodeMetrics(),
+		chunkSize:     defaultChunkSize,
+		maxObjectSize: defaultMaxObjectSize,
+		compressMinSize: defaultCompressMinSize,
+		done:     make(chan struct{}),
 	}
 
+	node.watches = newWatchHub(node.ID)
+	node.tracer = &tracer{node: node.ID}
+	node.peers = newPeerTracker()
+	node.cache = newHotCache(0, 0)
+	node.readers = newCacheReaders()
+	node.flights = newFlightGroup()
+	node.tombstones = newTombstones(time.Minute * tombstoneRetentionMinutes)
+	node.wire = newWireTransport(node)
+	node.state = &nodeState{}
+	node.UseLogger(defaultLogger())
+
 	node.service = new(restful.WebService)
-	node.service.Path(path).Consumes(restful.MIME_JSON).Produces(restful.MIME_JSON)
+	node.service.Path(path).Produces(restful.MIME_JSON)
+	node.service.Filter(node.instrument)
+	node.service.Filter(node.trace)
+	node.service.Filter(node.negotiateProtocol)
+	node.service.Filter(node.rejectWhileDraining)
 	node.service.Route(node.service.GET(pingPath).To(node.ping))
-	node.service.Route(node.service.GET(entitiesPath + "/{" + keyPath + "}").To(node.getValue))
-	node.service.Route(node.service.PUT(entitiesPath + "/{" + keyPath + "}").To(node.putValue))
-	node.service.Route(node.service.PUT(registerPath).To(node.registerNode))
-	node.service.Route(node.service.GET(nodesPath).To(node.getNodes))
+	node.service.Route(node.service.GET(healthzPath).To(node.getHealthz))
+	node.service.Route(node.service.GET(readyzPath).To(node.getReadyz))
+	node.service.Route(node.service.GET(handoffPath).Filter(node.requirePeer).To(node.getHandoff))
+	node.service.Route(node.service.GET(wirePath).Filter(node.requirePeer).To(node.upgradeWire))
+	node.service.Route(node.service.GET(metricsPath).Filter(node.authenticate).Filter(node.authorizeAdmin).To(node.getMetrics))
+	node.service.Route(node.service.GET(statusPath).Filter(node.authenticate).Filter(node.authorizeAdmin).To(node.getStatus))
+	node.service.Route(node.service.GET(ringPath).Filter(node.authenticate).Filter(node.authorizeAdmin).To(node.getRing))
+	node.service.Route(node.service.GET(locatePath).Filter(node.authenticate).Filter(node.authorizeAdmin).To(node.locateKey))
+	node.service.Route(node.service.GET(entitiesPath + "/{" + keyPath + "}").Produces(anyMime).Filter(node.authenticate).Filter(node.authorizeRead).To(node.getValue))
+	node.service.Route(node.service.PUT(entitiesPath + "/{" + keyPath + "}").Filter(node.authenticate).Filter(node.authorizeWrite).To(node.putValue))
+	node.service.Route(node.service.DELETE(entitiesPath + "/{" + keyPath + "}").Filter(node.authenticate).Filter(node.authorizeWrite).To(node.deleteValue))
+	node.service.Route(node.service.GET(entitiesPath).Filter(node.authenticate).To(node.listValues))
+	node.service.Route(node.service.GET(rangePath).Filter(node.authenticate).To(node.rangeValues))
+	node.service.Route(node.service.GET(watchPath).Filter(node.authenticate).To(node.watchValues))
+	node.service.Route(node.service.GET(changesPath).Filter(node.authenticate).To(node.getChanges))
+	node.service.Route(node.service.POST(batchGetPath).Consumes(restful.MIME_JSON).Filter(node.authenticate).To(node.batchGetValues))
+	node.service.Route(node.service.POST(batchPutPath).Consumes(restful.MIME_JSON).Filter(node.authenticate).To(node.batchPutValues))
+	node.service.Route(node.service.PUT(registerPath).Filter(node.requirePeer).To(node.registerNode))
+	node.service.Route(node.service.DELETE(registerPath).Filter(node.requirePeer).To(node.deregisterNode))
+	node.service.Route(node.service.GET(nodesPath).Filter(node.requirePeer).To(node.getNodes))
+	node.service.Route(node.service.DELETE(cachePath).Filter(node.requirePeer).To(node.invalidateCache))
+	node.service.Route(node.service.GET(namespacesPath).Filter(node.authenticate).To(node.getNamespaces))
+	node.service.Route(node.service.GET(namespacesPath + "/{" + namespaceParam + "}").Filter(node.authenticate).To(node.getNamespace))
+	node.service.Route(node.service.PUT(namespacesPath + "/{" + namespaceParam + "}").Consumes(restful.MIME_JSON).Filter(node.authenticate).Filter(node.authorizeWrite).To(node.putNamespace))
+	node.service.Route(node.service.DELETE(namespacesPath + "/{" + namespaceParam + "}").Filter(node.authenticate).Filter(node.authorizeWrite).To(node.deleteNamespace))
+	node.service.Route(node.service.GET(namespacesPath + "/{" + namespaceParam + "}" + entitiesPath + "/{" + keyPath + "}").Produces(anyMime).Filter(node.authenticate).Filter(node.authorizeRead).To(node.getNamespacedValue))
+	node.service.Route(node.service.PUT(namespacesPath + "/{" + namespaceParam + "}" + entitiesPath + "/{" + keyPath + "}").Filter(node.authenticate).Filter(node.authorizeWrite).To(node.putNamespacedValue))
+	node.service.Route(node.service.GET(namespacesPath + "/{" + namespaceParam + "}" + entitiesPath).Filter(node.authenticate).To(node.listNamespacedValues))
+	node.service.Route(node.service.GET(namespacesPath + "/{" + namespaceParam + "}" + rangePath).Filter(node.authenticate).To(node.rangeNamespacedValues))
+	node.service.Route(node.service.GET(namespacesPath + "/{" + namespaceParam + "}" + watchPath).Filter(node.authenticate).To(node.watchNamespacedValues))
+	node.service.Route(node.service.POST(namespacesPath + "/{" + namespaceParam + "}" + batchGetPath).Consumes(restful.MIME_JSON).Filter(node.authenticate).To(node.batchGetNamespacedValues))
+	node.service.Route(node.service.POST(namespacesPath + "/{" + namespaceParam + "}" + batchPutPath).Consumes(restful.MIME_JSON).Filter(node.authenticate).To(node.batchPutNamespacedValues))
+	node.service.Route(node.service.DELETE(namespacesPath + "/{" + namespaceParam + "}" + entitiesPath + "/{" + keyPath + "}").Filter(node.authenticate).Filter(node.authorizeWrite).To(node.deleteNamespacedValue))
 	restful.Add(node.service)
 	return node
 }
 
+func (n *Node) UseTLS(options *TLSOptions) error {
+	server, client, err := buildTLSConfigs(options, n.logger)
+	if err != nil {
+		return err
+	}
+
+	n.client.Transport.(*http.Transport).TLSClientConfig = client
+	if server != nil {
+		n.server.TLSConfig = server
+		n.Address = "https://" + strings.TrimPrefix(n.Address, "http://")
+	}
+	return nil
+}
+
+func (n *Node) UseLogger(logger Logger) {
+	n.logger = logger.With(F(nodeIDField, n.ID))
+	n.changes.logger = n.logger
+}
+
+func (n *Node) UseAuthentication(authenticators ...Authenticator) {
+	n.authMux.Lock()
+	defer n.authMux.Unlock()
+	n.authenticators = authenticators
+}
+
+func (n *Node) UseAuthorization(authorizer Authorizer) {
+	n.authMux.Lock()
+	defer n.authMux.Unlock()
+	n.authorizer = authorizer
+}
+
+func (n *Node) UseClusterSecret(secret string) {
+	n.authMux.Lock()
+	defer n.authMux.Unlock()
+	n.clusterSecret = secret
+}
+
+func (n *Node) authentication() []Authenticator {
+	n.authMux.RLock()
+	defer n.authMux.RUnlock()
+	return n.authenticators
+}
+
+func (n *Node) authorization() Authorizer {
+	n.authMux.RLock()
+	defer n.authMux.RUnlock()
+	return n.authorizer
+}
+
+func (n *Node) secret() string {
+	n.authMux.RLock()
+	defer n.authMux.RUnlock()
+	return n.clusterSecret
+}
+
 func (n *Node) Start() {
+	n.started = time.Now()
+	if n.secured() && n.secret() == "" {
+		n.logger.Warn("authentication is configured without a cluster secret, peer requests will be refused")
+	}
 	go func() {
-		log.Printf("starting server at node '%d' with address '%s'", n.ID, n.Address)
-		err := n.server.ListenAndServe()
-		if err != nil {
-			log.Printf("server error at node '%d': '%s'", n.ID, err)
+		n.logger.Info("starting server", F("address", n.Address))
+		var err error
+		if n.server.TLSConfig != nil {
+			err = n.server.ListenAndServeTLS("", "")
+		} else {
+			err = n.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			n.logger.Error("server error", F(errorField, err))
 		}
 	}()
 	n.registry.Put(n.ID, n.Address)
@@ -86,6 +256,32 @@ func (n *Node) Start() {
 	}()
 	n.tickers = append(n.tickers, syncValueTicker)
 
+	rejoinTicker := time.NewTicker(time.Second * rejoinFrequencySeconds)
+	go func() {
+		for {
+			select {
+			case <-rejoinTicker.C:
+				n.rejoin()
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	n.tickers = append(n.tickers, rejoinTicker)
+
+	expiryTicker := time.NewTicker(time.Second * expiryFrequencySeconds)
+	go func() {
+		for {
+			select {
+			case <-expiryTicker.C:
+				n.purgeExpired()
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	n.tickers = append(n.tickers, expiryTicker)
+
 	time.Sleep(time.Millisecond * 10)
 	n.waitStart()
 }
@@ -100,18 +296,28 @@ func (n *Node) waitStart() {
 
 func (n *Node) Stop() {
 	if n.server != nil {
-		log.Printf("stopping server at node '%d'", n.ID)
+		n.logger.Info("stopping server")
 		go func() {
-			err := n.server.Shutdown(nil)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*stopTimeoutSeconds)
+			defer cancel()
+			err := n.server.Shutdown(ctx)
 			if err != nil {
-				log.Printf("unable to stop server at node '%d': '%s'", n.ID, err)
+				n.logger.Error("unable to stop server", F(errorField, err))
+				n.server.Close()
 			}
+			n.wire.close(ctx)
 		}()
 
 		for _, ticker := range n.tickers {
 			ticker.Stop()
 		}
 
+		select {
+		case <-n.done:
+		default:
+			close(n.done)
+		}
+
 		n.registry.Delete(n.ID)
 		time.Sleep(time.Millisecond * 10)
 		n.waitStop()
@@ -126,40 +332,324 @@ func (n *Node) waitStop() {
 	}
 }
 
-func (n *Node) Connect(uri string) {
-	n.registerNodeRemote(uri)
-	n.syncNodeRegistryRemote(uri)
+func (n *Node) Connect(seeds ...string) error {
+	n.UseSeeds(seeds...)
+
+	for attempt := 0; ; attempt++ {
+		err := n.connectOnce()
+		if err == nil {
+			n.completeJoin()
+			return nil
+		}
+
+		wait := backoff(attempt, time.Millisecond*connectInitialBackoffMilliseconds, time.Second*connectMaxBackoffSeconds)
+		n.logger.Warn("unable to connect to seeds, retrying", F("wait", wait), F(errorField, err))
+		select {
+		case <-time.After(wait):
+		case <-n.done:
+			return errors.New("node stopped before connecting to a seed")
+		}
+	}
+}
+
+func (n *Node) completeJoin() {
+	n.state.set(func(ns *nodeState) { ns.joined = true })
+	err := n.handoff(context.Background())
+	if err != nil {
+		n.logger.Warn("initial handoff incomplete", F(errorField, err))
+	}
+	n.state.set(func(ns *nodeState) { ns.handedOff = true })
+}
+
+func (n *Node) connectOnce() error {
+	n.seedsMux.Lock()
+	seeds := n.seeds
+	n.seedsMux.Unlock()
+
+	uris := expandSeeds(seeds, n.logger)
+	if len(uris) == 0 {
+		return errors.New("no seed addresses available")
+	}
+
+	var err error
+	for _, uri := range uris {
+		if uri == n.Address {
+			continue
+		}
+
+		err = n.registerNodeRemote(uri)
+		if err == errSelfSeed {
+			n.logger.Debug("skipped seed that resolves to this node", F(peerField, uri))
+			err = nil
+			continue
+		}
+		if err != nil {
+			n.logger.Warn("unable to register with seed", F(peerField, uri), F(errorField, err))
+			continue
+		}
+
+		err = n.syncNodeRegistryRemote(uri)
+		if err != nil {
+			n.logger.Warn("unable to sync registry from seed", F(peerField, uri), F(errorField, err))
+			continue
+		}
+
+		err = n.syncNamespacesRemote(-1, uri)
+		if err != nil {
+			n.logger.Warn("unable to sync namespaces from seed", F(peerField, uri), F(errorField, err))
+			continue
+		}
+
+		n.logger.Info("connected to cluster", F(peerField, uri))
+		return nil
+	}
+
+	if err == nil {
+		err = errors.New("no seed addresses other than the local node")
+	}
+	return err
+}
+
+func (n *Node) rejoin() {
+	n.seedsMux.Lock()
+	seeds := len(n.seeds)
+	n.seedsMux.Unlock()
+
+	if seeds == 0 || n.registry.Size() > 1 {
+		return
+	}
+
+	n.logger.Info("isolated, attempting to rejoin cluster")
+	err := n.connectOnce()
+	if err != nil {
+		n.metrics.syncs.add(1, "rejoin", syncError)
+		n.logger.Warn("unable to rejoin cluster", F(errorField, err))
+		return
+	}
+	n.metrics.syncs.add(1, "rejoin", syncOK)
+	n.completeJoin()
+}
+
+func (n *Node) send(ctx context.Context, verb string, uri string, body string, header http.Header) (int, string, error) {
+	statusCode, b, _, err := n.exchange(ctx, verb, uri, body, header)
+	return statusCode, b, err
+}
+
+func (n *Node) exchange(ctx context.Context, verb string, uri string, body string, header http.Header) (int, string, http.Header, error) {
+	if secret := n.secret(); secret != "" {
+		header.Set(clusterSecretHeader, secret)
+	}
+	ctx, span := n.tracer.start(ctx, "peer "+verb)
+	defer span.End()
+	span.SetAttribute(peerField, uri)
+	header.Set(traceparentHeader, span.traceparent())
+	id, known := n.peerID(uri)
+	version := n.protocolFor(id, known)
+	setProtocol(header, version)
+
+	var statusCode int
+	var b string
+	var responseHeader http.Header
+	var err error
+	for attempt := 0; ; attempt++ {
+		if known && !n.available(id) {
+			statusCode, b, responseHeader, err = 0, "", nil, ErrPeerUnavailable
+			break
+		}
+		statusCode, b, responseHeader, err = n.attempt(ctx, verb, uri, body, header)
+		n.observePeer(uri, statusCode, err)
+		if known && statusCode == http.StatusUpgradeRequired {
+			if common := n.negotiate(parseProtocols(responseHeader.Get(protocolsHeader))); common > 0 && common < version {
+				n.logger.Info("downgrading protocol for peer", F(peerField, uri), F("protocol", common))
+				n.peers.setProtocol(id, common)
+				version = common
+				setProtocol(header, version)
+				statusCode, b, responseHeader, err = n.attempt(ctx, verb, uri, body, header)
+				n.observePeer(uri, statusCode, err)
+			}
+		}
+		if known && err == nil {
+			n.observeProtocol(id, statusCode, responseHeader)
+		}
+		if attempt >= n.peerOptions.Retries || ctx.Err() != nil || !idempotent(verb, header) || !retryable(statusCode, err) {
+			break
+		}
+
+		n.metrics.peerRetries.add(1, peerLabel(id, known))
+		wait := backoff(attempt, n.peerOptions.RetryBackoff, defaultPeerMaxRetryBackoff)
+		n.logger.Debug("retrying peer request", F(peerField, uri), F("wait", wait), F("status", statusCode), F(errorField, err))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	span.SetAttribute("status", strconv.Itoa(statusCode))
+	span.SetError(err)
+	return statusCode, b, responseHeader, err
+}
+
+func (n *Node) attempt(ctx context.Context, verb string, uri string, body string, header http.Header) (int, string, http.Header, error) {
+	if _, bounded := ctx.Deadline(); !bounded {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.peerOptions.Timeout)
+		defer cancel()
+	}
+	if n.wire.isEnabled() {
+		if id, known := n.peerID(uri); known && id != n.ID {
+			statusCode, b, responseHeader, err := n.wire.exchange(ctx, n.registry.Get(id), verb, uri, body, header)
+			if err != errWireUnsupported {
+				return statusCode, b, responseHeader, err
+			}
+		}
+	}
+	return exchange(ctx, n.client, verb, uri, body, header)
+}
+
+func (n *Node) secured() bool {
+	return len(n.authentication()) > 0 || n.authorization() != nil
+}
+
+// Without a cluster secret, peers can only be told apart by the visited header, which
+// is trusted only in open clusters where clients may already do anything.
+func (n *Node) isPeer(request *restful.Request) bool {
+	secret := n.secret()
+	if secret == "" {
+		return !n.secured() && request.HeaderParameter(visitedHeader) != ""
+	}
+	return secureEquals(request.HeaderParameter(clusterSecretHeader), secret)
+}
+
+func (n *Node) requirePeer(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if (n.secret() != "" || n.secured()) && !n.isPeer(request) {
+		n.logger.Warn("rejected peer request without cluster secret", F("remote", request.Request.RemoteAddr))
+		response.WriteErrorString(http.StatusForbidden, "cluster secret required")
+		return
+	}
+	chain.ProcessFilter(request, response)
+}
+
+func (n *Node) authenticate(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if n.isPeer(request) {
+		request.SetAttribute(principalAttribute, peerPrincipal)
+		chain.ProcessFilter(request, response)
+		return
+	}
+	authenticators := n.authentication()
+	if len(authenticators) == 0 {
+		request.SetAttribute(principalAttribute, anonymousPrincipal)
+		chain.ProcessFilter(request, response)
+		return
+	}
+
+	principal, err := authenticate(authenticators, request.Request)
+	if err != nil {
+		n.logger.Warn("rejected unauthenticated request", F("remote", request.Request.RemoteAddr), F(errorField, err))
+		response.AddHeader("WWW-Authenticate", "Bearer")
+		response.WriteErrorString(http.StatusUnauthorized, err.Error())
+		return
+	}
+	request.SetAttribute(principalAttribute, principal)
+	chain.ProcessFilter(request, response)
+}
+
+func (n *Node) authorizeRead(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	n.authorize(request, response, chain, false)
+}
+
+func (n *Node) authorizeWrite(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	n.authorize(request, response, chain, true)
+}
+
+func (n *Node) authorize(request *restful.Request, response *restful.Response, chain *restful.FilterChain, write bool) {
+	principal, _ := request.Attribute(principalAttribute).(string)
+	authorizer := n.authorization()
+	if principal == peerPrincipal || authorizer == nil {
+		chain.ProcessFilter(request, response)
+		return
+	}
+
+	key, err := authorizationKey(request)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if !authorizer.Authorize(principal, key, write) {
+		n.logger.Warn("denied access to key", F("principal", principal), F(keyField, key))
+		response.WriteErrorString(http.StatusForbidden, "access to key denied")
+		return
+	}
+	chain.ProcessFilter(request, response)
 }
 
 func (n *Node) ping(request *restful.Request, response *restful.Response) {
 	response.WriteHeader(http.StatusOK)
-	log.Printf("node '%d' responded to ping", n.ID)
+	n.logger.Debug("responded to ping")
 }
 
 func (n *Node) pingRemote(address string) (int, string, error) {
 	uri := address + pingPath
-	log.Printf("node '%d' sending ping request to address '%s'", n.ID, uri)
-	return send("GET", uri, "", []int{n.ID}, 1)
+	n.logger.Debug("sending ping request", F(peerField, uri))
+	return n.send(context.Background(), "GET", uri, "", buildPeerHeader([]int{n.ID}, 1))
 }
 
 func (n *Node) Get(key string) string {
-	value := n.store.Get(key)
-	log.Printf("retrieved value for key '%s' from node '%d'", key, n.ID)
-	return value
+	entry, found := n.lookup(key)
+	if !found {
+		return ""
+	}
+	n.logger.Debug("retrieved value", F(keyField, key))
+	entry, err := decodeEntry(entry)
+	if err != nil {
+		n.logger.Warn("unable to decompress value", F(keyField, key), F(errorField, err))
+		return ""
+	}
+	if isManifest(entry.Value) {
+		reader, err := n.GetReader(key)
+		if err != nil {
+			return ""
+		}
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			n.logger.Warn("unable to read chunked value", F(keyField, key), F(errorField, err))
+			return ""
+		}
+		return string(b)
+	}
+	return string(entry.Value)
+}
+
+func (n *Node) lookup(key string) (*Entry, bool) {
+	entry := n.store.GetEntry(key)
+	if entry == nil {
+		return nil, false
+	}
+	if entry.Expired(time.Now()) {
+		n.expireEntry(key, entry.Version)
+		entry = n.store.GetEntry(key)
+		if entry == nil || entry.Expired(time.Now()) {
+			return nil, false
+		}
+	}
+	return entry, true
 }
 
 func (n *Node) getValue(request *restful.Request, response *restful.Response) {
-	key, err := url.QueryUnescape(request.PathParameter(keyPath))
-	if err != nil {
-		response.WriteError(http.StatusInternalServerError, err)
+	key, ok := entityKey(request, response, defaultNamespace)
+	if !ok {
 		return
 	}
+	n.serveValue(request, response, key)
+}
 
-	if n.store.Contains(key) {
-		value := n.Get(key)
-		b := []byte(value)
-		response.WriteHeader(http.StatusOK)
-		response.Write(b)
+func (n *Node) serveValue(request *restful.Request, response *restful.Response, key string) {
+	if entry, found := n.lookupShared(request.Request.Context(), key); found {
+		n.logger.Debug("retrieved value", F(keyField, key))
+		n.recordReaders(request, key)
+		n.writeEntry(request, response, entry)
 		return
 	}
 
@@ -169,66 +659,261 @@ func (n *Node) getValue(request *restful.Request, response *restful.Response) {
 		response.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if entry, found := n.cachedValue(key); found {
+		n.logger.Debug("retrieved cached value", F(keyField, key))
+		n.writeEntry(request, response, entry)
+		return
+	}
 	hops--
 	visited = append(visited, n.ID)
-	next := n.bestMatch(key, visited)
+	next := n.availableMatch(key, visited)
 	if next < 0 {
 		response.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	address := n.registry.Get(next)
-	statusCode, body, err := n.getValueRemote(address, key, visited, hops)
+	header := buildPeerHeader(visited, hops)
+	header.Set("Accept-Encoding", gzipCodec)
+	header.Set(sizeLimitHeader, strconv.Itoa(n.chunkSize))
+	if !n.requestCaching(request, header) {
+		copyHeaders(header, request.Request.Header, "If-None-Match")
+	}
+	statusCode, body, responseHeader, err := n.forwardGet(request.Request.Context(), address+entityPath(key), header)
 	if err != nil {
 		response.WriteError(http.StatusInternalServerError, err)
 		return
 	}
+	if statusCode == http.StatusRequestEntityTooLarge && responseHeader.Get(sizeLimitHeader) != "" && request.HeaderParameter(sizeLimitHeader) == "" {
+		n.streamValue(request, response, address+entityPath(key), buildPeerHeader(visited, hops))
+		return
+	}
+	if statusCode == http.StatusOK {
+		n.cacheValue(key, body, responseHeader)
+	}
+	copyHeaders(response.Header(), responseHeader, "ETag", expiresHeader, sizeLimitHeader)
 	if statusCode != http.StatusOK {
 		response.WriteHeader(statusCode)
 		return
 	}
+	if version, err := parseETag(responseHeader.Get("ETag")); err == nil && matchesETag(request.HeaderParameter("If-None-Match"), version, true) {
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+	copyContentHeaders(response.Header(), responseHeader)
+	n.writeValue(request, response, &Entry{Value: []byte(body), Codec: responseHeader.Get("Content-Encoding")})
+}
 
-	response.WriteHeader(http.StatusOK)
-	response.Write([]byte(body))
+func (n *Node) writeEntry(request *restful.Request, response *restful.Response, entry *Entry) {
+	if !entry.Expiry.IsZero() {
+		response.AddHeader(expiresHeader, formatExpiry(entry.Expiry))
+	}
+	response.AddHeader("ETag", formatETag(entry.Version))
+	if matchesETag(request.HeaderParameter("If-None-Match"), entry.Version, true) {
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeContentHeaders(response.Header(), entry)
+	n.writeValue(request, response, entry)
 }
 
-func (n *Node) getValueRemote(address string, key string, visited []int, hops int) (int, string, error) {
-	uri := address + entitiesPath + "/" + url.QueryEscape(key)
-	log.Printf("node '%d' sending get value request to address '%s'", n.ID, uri)
-	return send("GET", uri, "", visited, hops)
+func (n *Node) getValueRemote(ctx context.Context, address string, key string, visited []int, hops int) (int, string, error) {
+	uri := address + entityPath(key)
+	n.logger.Debug("sending get value request", F(peerField, uri), F(hopsField, hops))
+	return n.send(ctx, "GET", uri, "", buildPeerHeader(visited, hops))
 }
 
 func (n *Node) Put(key string, value string) {
-	n.store.Put(key, value)
-	log.Printf("wrote key '%s' and associated value to node '%d'", key, n.ID)
+	n.commitEntry(key, &Entry{Value: []byte(value)}, "", "")
+}
+
+func (n *Node) PutWithTTL(key string, value string, ttl time.Duration) {
+	n.commitEntry(key, &Entry{Value: []byte(value), Expiry: time.Now().Add(ttl)}, "", "")
+}
+
+func (n *Node) putEntry(key string, entry *Entry, origin string) {
+	n.store.PutEntry(key, n.compressEntry(key, entry))
+	n.invalidate(key)
+	plain, err := decodeEntry(entry)
+	if err != nil {
+		n.logger.Warn("unable to decompress value", F(keyField, key), F(errorField, err))
+		plain = entry
+	}
+	n.changes.append(chunkOrigin(key, origin), PutEvent, key, plain)
+	n.notify(PutEvent, key, plain)
+	n.logger.Debug("wrote value", F(keyField, key), F("origin", origin))
+}
+
+func (n *Node) commitEntry(key string, entry *Entry, ifMatch string, ifNoneMatch string) error {
+	n.writeMux.Lock()
+	defer n.writeMux.Unlock()
+	var version uint64
+	current := n.store.GetEntry(key)
+	if current != nil && current.Expired(time.Now()) {
+		n.expireLocked(key, current.Version)
+		current = nil
+	}
+	exists := current != nil
+	if exists {
+		version = current.Version
+	}
+	if ifMatch != "" && !matchesETag(ifMatch, version, exists) {
+		return ErrPreconditionFailed
+	}
+	if ifNoneMatch != "" && matchesETag(ifNoneMatch, version, exists) {
+		return ErrPreconditionFailed
+	}
+
+	entry.Version = nextVersion(n.latestVersion(version, key))
+	n.putEntry(key, entry, ClientOrigin)
+	n.replaced(key, current, entry)
+	return nil
+}
+
+func (n *Node) applyEntry(key string, entry *Entry) bool {
+	n.writeMux.Lock()
+	defer n.writeMux.Unlock()
+	current := n.store.GetEntry(key)
+	if current != nil && current.Version >= entry.Version {
+		n.logger.Debug("ignored stale version", F(keyField, key), F("version", entry.Version))
+		return false
+	}
+	if n.deleted(key, entry.Version) || entry.Expired(time.Now()) {
+		n.logger.Debug("ignored deleted version", F(keyField, key), F("version", entry.Version))
+		return false
+	}
+	n.putEntry(key, entry, ReplicationOrigin)
+	n.replaced(key, current, entry)
+	return true
 }
 
 func (n *Node) putValue(request *restful.Request, response *restful.Response) {
-	key := request.PathParameter(keyPath)
-	bytes, err := ioutil.ReadAll(request.Request.Body)
+	key, ok := entityKey(request, response, defaultNamespace)
+	if !ok {
+		return
+	}
+	n.storeValue(request, response, key, nil)
+}
+
+func (n *Node) storeValue(request *restful.Request, response *restful.Response, key string, ns *Namespace) {
+	expiry, err := parseExpiry(&request.Request.Header)
 	if err != nil {
-		response.WriteError(http.StatusInternalServerError, err)
+		response.WriteError(http.StatusBadRequest, err)
 		return
 	}
-	value := string(bytes)
-	n.Put(key, value)
+	client := !n.isPeer(request)
+	var value []byte
+	if !client {
+		value, err = ioutil.ReadAll(request.Request.Body)
+		if err != nil {
+			response.WriteError(http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		value, err = n.readClientValue(request, ns, expiry)
+		switch err {
+		case nil:
+		case ErrObjectTooLarge:
+			response.WriteErrorString(http.StatusRequestEntityTooLarge, err.Error())
+			return
+		case ErrReservedValue:
+			response.WriteErrorString(http.StatusBadRequest, err.Error())
+			return
+		default:
+			response.WriteError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	entry := &Entry{Value: value, Expiry: expiry}
+	entry.ContentType, entry.Meta = readContentHeaders(request.Request.Header)
+	if !client && request.HeaderParameter("Content-Encoding") != "" {
+		entry.Codec = request.HeaderParameter("Content-Encoding")
+		if _, err := decodeEntry(entry); err != nil {
+			response.WriteError(http.StatusBadRequest, err)
+			return
+		}
+	}
 
 	visited, _ := parseVisited(&request.Request.Header)
-	hops, _ := parseHops(&request.Request.Header)
+	sync := request.HeaderParameter(syncHeader) != ""
+	hops, err := parseHops(&request.Request.Header)
+	if ns != nil && (err != nil || hops > ns.Replicas) {
+		hops = ns.Replicas
+	}
+
+	version, replicated, err := parseVersion(&request.Request.Header)
+	if err == nil && replicated && !n.isPeer(request) {
+		err = fmt.Errorf("'%s' is only accepted from peers", versionHeader)
+	}
+	if err != nil {
+		if client {
+			n.releaseValue(value)
+		}
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if replicated {
+		entry.Version = version
+		applied := n.applyEntry(key, entry)
+		if !applied && n.deleted(key, version) {
+			response.WriteErrorString(http.StatusGone, "value was deleted at a newer version")
+			return
+		}
+		if applied && !sync {
+			n.observeReplication(version)
+		}
+	} else {
+		owner := n.bestMatch(key, []int{})
+		if request.HeaderParameter("If-Match") == "" && request.HeaderParameter("If-None-Match") == "" {
+			owner = n.availableMatch(key, []int{})
+		}
+		if owner >= 0 && owner != n.ID && !containsID(visited, owner) {
+			n.forwardToOwner(request, response, n.registry.Get(owner), key, entry, append(visited, n.ID), hops)
+			if client && response.StatusCode() != http.StatusOK {
+				n.releaseValue(value)
+			}
+			return
+		}
+
+		entry = n.compressEntry(key, entry)
+		if ns != nil {
+			statusCode, err := n.checkNamespaceLimits(ns, key, entry.Value)
+			if err != nil {
+				n.logger.Info("rejected write", F(keyField, key), F(errorField, err))
+				if client {
+					n.releaseValue(value)
+				}
+				response.WriteErrorString(statusCode, err.Error())
+				return
+			}
+		}
+
+		err = n.commitEntry(key, entry, request.HeaderParameter("If-Match"), request.HeaderParameter("If-None-Match"))
+		if err != nil {
+			n.logger.Info("rejected write", F(keyField, key), F(errorField, err))
+			if client {
+				n.releaseValue(value)
+			}
+			response.WriteErrorString(http.StatusPreconditionFailed, err.Error())
+			return
+		}
+	}
+	response.AddHeader("ETag", formatETag(entry.Version))
+
 	if hops <= 0 {
 		response.WriteHeader(http.StatusOK)
 		return
 	}
 	hops--
 	visited = append(visited, n.ID)
-	next := n.bestMatch(key, visited)
+	next := n.availableMatch(key, visited)
 	if next < 0 {
 		response.WriteHeader(http.StatusOK)
 		return
 	}
 
 	address := n.registry.Get(next)
-	statusCode, body, err := n.putValueRemote(address, key, value, visited, hops)
+	statusCode, body, err := n.sendEntryRemote(request.Request.Context(), address, key, entry, visited, hops, sync)
 	if err != nil {
 		response.WriteError(http.StatusInternalServerError, err)
 		return
@@ -242,10 +927,209 @@ func (n *Node) putValue(request *restful.Request, response *restful.Response) {
 	response.Write([]byte(body))
 }
 
-func (n *Node) putValueRemote(address string, key string, value string, visited []int, hops int) (int, string, error) {
-	uri := address + entitiesPath + "/" + url.QueryEscape(key)
-	log.Printf("node '%d' sending put value request to address '%s'", n.ID, uri)
-	return send("PUT", uri, value, visited, hops)
+func (n *Node) putValueRemote(ctx context.Context, address string, key string, value string, visited []int, hops int) (int, string, error) {
+	return n.putEntryRemote(ctx, address, key, &Entry{Value: []byte(value)}, visited, hops)
+}
+
+func (n *Node) putEntryRemote(ctx context.Context, address string, key string, entry *Entry, visited []int, hops int) (int, string, error) {
+	return n.sendEntryRemote(ctx, address, key, entry, visited, hops, false)
+}
+
+func (n *Node) sendEntryRemote(ctx context.Context, address string, key string, entry *Entry, visited []int, hops int, sync bool) (int, string, error) {
+	uri := address + entityPath(key)
+	n.logger.Debug("sending put value request", F(peerField, uri), F(hopsField, hops))
+	header := buildPeerHeader(visited, hops)
+	if sync {
+		header.Set(syncHeader, "true")
+	}
+	if !entry.Expiry.IsZero() {
+		header.Set(expiresHeader, formatExpiry(entry.Expiry))
+	}
+	if entry.Version > 0 {
+		header.Set(versionHeader, strconv.FormatUint(entry.Version, 10))
+	}
+	setContentHeaders(header, entry)
+	entry, err := n.peerEntry(uri, entry, header)
+	if err != nil {
+		return 0, "", err
+	}
+	return n.send(ctx, "PUT", uri, string(entry.Value), header)
+}
+
+func (n *Node) forwardToOwner(request *restful.Request, response *restful.Response, address string, key string, entry *Entry, visited []int, hops int) {
+	uri := address + entityPath(key)
+	n.logger.Debug("forwarding put value request to owner", F(peerField, uri), F(hopsField, hops))
+	header := buildPeerHeader(visited, hops)
+	if !entry.Expiry.IsZero() {
+		header.Set(expiresHeader, formatExpiry(entry.Expiry))
+	}
+	copyHeaders(header, request.Request.Header, "If-Match", "If-None-Match")
+	setContentHeaders(header, entry)
+
+	n.cache.remove(key)
+	statusCode, body, responseHeader, err := n.exchange(request.Request.Context(), "PUT", uri, string(entry.Value), header)
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	copyHeaders(response.Header(), responseHeader, "ETag")
+	response.WriteHeader(statusCode)
+	response.Write([]byte(body))
+}
+
+func (n *Node) Delete(key string) {
+	statusCode, _, err := n.removeKey(context.Background(), key, []int{}, n.replicasFor(key), 0, false)
+	if err != nil || statusCode != http.StatusOK {
+		n.logger.Warn("unable to delete value", F(keyField, key), F("status", statusCode), F(errorField, err))
+	}
+}
+
+func (n *Node) latestVersion(version uint64, key string) uint64 {
+	if deleted := n.tombstones.version(key); deleted > version {
+		return deleted
+	}
+	return version
+}
+
+func (n *Node) deleted(key string, version uint64) bool {
+	return version <= n.tombstones.version(key)
+}
+
+func (n *Node) removeEntry(key string, version uint64, origin string) uint64 {
+	n.writeMux.Lock()
+	defer n.writeMux.Unlock()
+	current := n.store.GetEntry(key)
+	if version == 0 {
+		if current != nil {
+			version = current.Version
+		}
+		version = nextVersion(n.latestVersion(version, key))
+	}
+	n.tombstones.record(key, version, time.Now())
+	if current != nil && current.Version > version {
+		n.logger.Debug("ignored stale delete", F(keyField, key), F("version", version))
+		return version
+	}
+	n.deleteEntry(key, origin)
+	return version
+}
+
+func (n *Node) deleteEntry(key string, origin string) {
+	previous := n.store.GetEntry(key)
+	if previous == nil {
+		return
+	}
+	n.store.Delete(key)
+	n.invalidate(key)
+	n.replaced(key, previous, nil)
+	n.changes.append(chunkOrigin(key, origin), DeleteEvent, key, nil)
+	n.notify(DeleteEvent, key, nil)
+	n.logger.Debug("deleted value", F(keyField, key), F("origin", origin))
+}
+
+func (n *Node) expireEntry(key string, version uint64) {
+	n.writeMux.Lock()
+	defer n.writeMux.Unlock()
+	n.expireLocked(key, version)
+}
+
+func (n *Node) expireLocked(key string, version uint64) {
+	entry := n.store.GetEntry(key)
+	if entry == nil || entry.Version != version || !entry.Expired(time.Now()) {
+		return
+	}
+	n.tombstones.record(key, version, time.Now())
+	n.store.Delete(key)
+	n.invalidate(key)
+	n.changes.append(SystemOrigin, ExpireEvent, key, nil)
+	n.notify(ExpireEvent, key, nil)
+	n.logger.Debug("expired value", F(keyField, key))
+}
+
+func (n *Node) deleteValue(request *restful.Request, response *restful.Response) {
+	key, ok := entityKey(request, response, defaultNamespace)
+	if !ok {
+		return
+	}
+	n.removeValue(request, response, key)
+}
+
+func (n *Node) removeValue(request *restful.Request, response *restful.Response, key string) {
+	visited, _ := parseVisited(&request.Request.Header)
+	hops, err := parseHops(&request.Request.Header)
+	if err != nil {
+		hops = n.replicasFor(key)
+	}
+	version, replicated, err := parseVersion(&request.Request.Header)
+	if err == nil && replicated && !n.isPeer(request) {
+		err = fmt.Errorf("'%s' is only accepted from peers", versionHeader)
+	}
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, version, err := n.removeKey(request.Request.Context(), key, visited, hops, version, replicated)
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	response.AddHeader(versionHeader, strconv.FormatUint(version, 10))
+	response.WriteHeader(statusCode)
+}
+
+func (n *Node) removeKey(ctx context.Context, key string, visited []int, hops int, version uint64, replicated bool) (int, uint64, error) {
+	origin := ReplicationOrigin
+	if !replicated {
+		owner := n.availableMatch(key, []int{})
+		if owner >= 0 && owner != n.ID && !containsID(visited, owner) {
+			statusCode, version, err := n.deleteValueRemote(ctx, n.registry.Get(owner), key, append(visited, n.ID), hops, 0)
+			if err == nil && statusCode == http.StatusOK && n.store.Contains(key) {
+				n.removeEntry(key, version, ReplicationOrigin)
+			}
+			return statusCode, version, err
+		}
+		origin = ClientOrigin
+	}
+	version = n.removeEntry(key, version, origin)
+
+	if hops <= 0 {
+		return http.StatusOK, version, nil
+	}
+	hops--
+	visited = append(visited, n.ID)
+	next := n.availableMatch(key, visited)
+	if next < 0 {
+		return http.StatusOK, version, nil
+	}
+	statusCode, _, err := n.deleteValueRemote(ctx, n.registry.Get(next), key, visited, hops, version)
+	return statusCode, version, err
+}
+
+func (n *Node) deleteValueRemote(ctx context.Context, address string, key string, visited []int, hops int, version uint64) (int, uint64, error) {
+	uri := address + entityPath(key)
+	n.logger.Debug("sending delete value request", F(peerField, uri), F(hopsField, hops))
+	header := buildPeerHeader(visited, hops)
+	if version > 0 {
+		header.Set(versionHeader, strconv.FormatUint(version, 10))
+	}
+	statusCode, _, responseHeader, err := n.exchange(ctx, "DELETE", uri, "", header)
+	if err != nil {
+		return statusCode, 0, err
+	}
+	version, _, _ = parseVersion(&responseHeader)
+	return statusCode, version, nil
+}
+
+func (n *Node) purgeExpired() {
+	now := time.Now()
+	for _, key := range n.store.GetKeys(0, n.store.Size()) {
+		entry := n.store.GetEntry(key)
+		if entry != nil && entry.Expired(now) {
+			n.expireEntry(key, entry.Version)
+		}
+	}
+	n.tombstones.prune(now)
 }
 
 func (n *Node) registerNode(request *restful.Request, response *restful.Response) {
@@ -265,21 +1149,65 @@ func (n *Node) registerNode(request *restful.Request, response *restful.Response
 		return
 	}
 
+	offered := parseProtocols(request.HeaderParameter(protocolsHeader))
+	version := n.negotiate(offered)
+	response.AddHeader(protocolsHeader, formatVersions(n.protocols))
+	response.AddHeader(nodeIDHeader, strconv.Itoa(n.ID))
+	if id == n.ID {
+		response.WriteHeader(http.StatusOK)
+		return
+	}
+	if version == 0 {
+		n.logger.Warn("refused node with incompatible protocol", F(peerField, address), F("protocols", formatVersions(offered)))
+		response.WriteErrorString(http.StatusUpgradeRequired, fmt.Sprintf("node speaks protocol versions %s but this node speaks %s", formatVersions(offered), formatVersions(n.protocols)))
+		return
+	}
+
 	n.registry.Put(id, address)
-	log.Printf("registered node '%d' with node '%d'", id, n.ID)
+	n.peers.setProtocol(id, version)
+	n.logger.Info("registered node", F(peerField, address), F("peer_id", id), F("protocol", version))
 }
 
 func (n *Node) registerNodeRemote(address string) error {
 	uri := address + registerPath + "?" + idParam + "=" + strconv.Itoa(n.ID) + "&" + addressParam + "=" + n.Address
-	log.Printf("node '%d' sending register request to address '%s'", n.ID, uri)
-	_, _, err := send("PUT", uri, "", []int{n.ID}, 0)
-	return err
+	n.logger.Debug("sending register request", F(peerField, uri))
+	header := buildPeerHeader([]int{n.ID}, 0)
+	header.Set(protocolsHeader, formatVersions(n.protocols))
+	statusCode, body, responseHeader, err := n.exchange(context.Background(), "PUT", uri, "", header)
+	if err != nil {
+		return err
+	}
+	if statusCode == http.StatusUpgradeRequired {
+		return fmt.Errorf("incompatible protocol registering with '%s', %s", address, strings.TrimSpace(body))
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code '%d' registering with '%s'", statusCode, address)
+	}
+	if id, err := strconv.Atoi(responseHeader.Get(nodeIDHeader)); err == nil && id == n.ID {
+		return errSelfSeed
+	}
+
+	offered := parseProtocols(responseHeader.Get(protocolsHeader))
+	version := n.negotiate(offered)
+	if version == 0 {
+		return fmt.Errorf("incompatible protocol registering with '%s', it speaks %s but this node speaks %s", address, formatVersions(offered), formatVersions(n.protocols))
+	}
+	if id, known := n.peerID(uri); known {
+		n.peers.setProtocol(id, version)
+	}
+	return nil
 }
 
 func (n *Node) getNodes(request *restful.Request, response *restful.Response) {
+	if requestProtocol(request) >= 2 {
+		nodes := n.registryInfo()
+		response.WriteEntity(nodes)
+		n.logger.Debug("provided registered nodes", F("nodes", len(nodes)))
+		return
+	}
 	nodes := n.registry.GetAll()
 	response.WriteEntity(nodes)
-	log.Printf("provided '%d' nodes registered to node '%d'", len(nodes), n.ID)
+	n.logger.Debug("provided registered nodes", F("nodes", len(nodes)))
 }
 
 func (n *Node) updateRandomValue() {
@@ -288,7 +1216,11 @@ func (n *Node) updateRandomValue() {
 	}
 
 	key := n.store.GetRandomKey()
-	matches := n.bestMatches(key, 3, []int{})
+	entry, found := n.lookup(key)
+	if !found {
+		return
+	}
+	matches := n.bestMatches(key, n.replicasFor(key)+1, []int{})
 	best := false
 	for _, m := range matches {
 		if m == n.ID {
@@ -296,12 +1228,23 @@ func (n *Node) updateRandomValue() {
 		}
 	}
 
-	match := n.bestMatch(key, []int{n.ID})
+	match := n.availableMatch(key, []int{n.ID})
 	address := n.registry.Get(match)
-	n.putValueRemote(address, key, n.store.Get(key), []int{n.ID}, redundantCopies)
+	statusCode, _, err := n.sendEntryRemote(context.Background(), address, key, entry, []int{n.ID}, n.replicasFor(key), true)
+	if err == nil && statusCode == http.StatusGone {
+		n.removeEntry(key, entry.Version, SystemOrigin)
+		n.metrics.syncs.add(1, "value", syncOK)
+		return
+	}
+	if err != nil || statusCode != http.StatusOK {
+		n.metrics.syncs.add(1, "value", syncError)
+		n.logger.Warn("unable to copy value", F(keyField, key), F(peerField, address), F("status", statusCode), F(errorField, err))
+		return
+	}
+	n.metrics.syncs.add(1, "value", syncOK)
 
 	if !best {
-		n.store.Delete(key)
+		n.deleteEntry(key, SystemOrigin)
 	}
 }
 
@@ -317,32 +1260,65 @@ func (n *Node) syncRandomNodeRemote() {
 func (n *Node) syncNodeRemote(id int) {
 	address := n.registry.Get(id)
 	uri := address + pingPath
-	log.Printf("node '%d' sending sync request to address '%s'", n.ID, uri)
-	statusCode, _, err := send("GET", uri, "", []int{n.ID}, 1)
+	n.logger.Debug("sending sync request", F(peerField, uri))
+	statusCode, _, err := n.send(context.Background(), "GET", uri, "", buildPeerHeader([]int{n.ID}, 1))
 	if err != nil || statusCode != http.StatusOK {
 		n.registry.Delete(id)
-		log.Printf("removed node '%d' from node '%d' registry", id, n.ID)
+		n.namespaces.removePeer(id)
+		n.metrics.syncs.add(1, "node", syncRemoved)
+		n.logger.Info("removed node from registry", F(peerField, address), F("peer_id", id))
 	} else {
 		err = n.syncNodeRegistryRemote(address)
+		if err == nil {
+			err = n.syncNamespacesRemote(id, address)
+		}
+		if err != nil {
+			n.metrics.syncs.add(1, "node", syncError)
+			n.logger.Warn("unable to sync with node", F(peerField, address), F(errorField, err))
+			return
+		}
+		n.metrics.syncs.add(1, "node", syncOK)
 	}
 }
 
 func (n *Node) syncNodeRegistryRemote(address string) error {
 	uri := address + nodesPath
-	log.Printf("node '%d' sending sync registry request to address '%s'", n.ID, uri)
-	_, body, err := send("GET", uri, "", []int{n.ID}, 0)
+	n.logger.Debug("sending sync registry request", F(peerField, uri))
+	statusCode, body, header, err := n.exchange(context.Background(), "GET", uri, "", buildPeerHeader([]int{n.ID}, 0))
 	if err != nil {
 		return err
 	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code '%d' syncing registry from '%s'", statusCode, address)
+	}
 
-	nodes := &map[int]string{}
-	err = json.Unmarshal([]byte(body), nodes)
+	if responseProtocol(header) < 2 {
+		nodes := &map[int]string{}
+		err = json.Unmarshal([]byte(body), nodes)
+		if err != nil {
+			return err
+		}
+		for id, address := range *nodes {
+			n.registry.Put(id, address)
+		}
+		if id, known := n.peerID(uri); known && n.peers.protocol(id) == 0 && n.negotiate([]int{legacyProtocol}) > 0 {
+			n.peers.setProtocol(id, legacyProtocol)
+		}
+		return nil
+	}
+
+	nodes := make([]nodeInfo, 0)
+	err = json.Unmarshal([]byte(body), &nodes)
 	if err != nil {
 		return err
 	}
-
-	for id, address := range *nodes {
-		n.registry.Put(id, address)
+	for _, node := range nodes {
+		n.registry.Put(node.ID, node.Address)
+		if node.ID != n.ID && node.Protocol > 0 && n.peers.protocol(node.ID) == 0 {
+			if version := n.negotiate([]int{node.Protocol}); version > 0 {
+				n.peers.setProtocol(node.ID, version)
+			}
+		}
 	}
 	return nil
 }
@@ -360,6 +1336,20 @@ func (n *Node) bestMatches(s string, count int, excludes []int) []int {
 	return matches
 }
 
+func (n *Node) availableMatch(s string, excludes []int) int {
+	skipped := make([]int, 0)
+	for {
+		match := n.bestMatch(s, append(append([]int{}, excludes...), skipped...))
+		if match < 0 && len(skipped) > 0 {
+			return skipped[0]
+		}
+		if match < 0 || n.available(match) {
+			return match
+		}
+		skipped = append(skipped, match)
+	}
+}
+
 func (n *Node) bestMatch(s string, excludes []int) int {
 	keys := make([]int, 0)
 	x := make(map[int]bool, len(excludes))
@@ -377,7 +1367,6 @@ func (n *Node) bestMatch(s string, excludes []int) int {
 			keys = append(keys, id)
 		}
 	}
-
 	if len(keys) == 0 {
 		return -1
 	}
@@ -0,0 +1,181 @@
+package corduroy
+
+import (
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const adminPath = "/admin"
+const statusPath = adminPath + "/status"
+const ringPath = adminPath + "/ring"
+const locatePath = adminPath + "/locate"
+
+const ringSize = 1 << 32
+
+var Version = "dev"
+
+type NodeStatus struct {
+	ID         int                           `json:"id"`
+	Address    string                        `json:"address"`
+	Version    string                        `json:"version"`
+	Started    time.Time                     `json:"started"`
+	Uptime     float64                       `json:"uptimeSeconds"`
+	Keys       int                           `json:"keys"`
+	Bytes      int                           `json:"bytes"`
+	Nodes      int                           `json:"nodes"`
+	Namespaces int                           `json:"namespaces"`
+	Sync       map[string]map[string]float64 `json:"sync"`
+	Peers      []PeerStatus                  `json:"peers"`
+}
+
+type PeerStatus struct {
+	ID          int       `json:"id"`
+	Address     string    `json:"address"`
+	Healthy     bool      `json:"healthy"`
+	Requests    float64   `json:"requests"`
+	Errors      float64   `json:"errors"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastFailure time.Time `json:"lastFailure"`
+	CircuitOpen bool      `json:"circuitOpen"`
+	Protocol    int       `json:"protocol"`
+}
+
+type RingSegment struct {
+	ID      int     `json:"id"`
+	Address string  `json:"address"`
+	Token   int     `json:"token"`
+	Start   int     `json:"start"`
+	End     int     `json:"end"`
+	Percent float64 `json:"percent"`
+}
+
+type KeyLocation struct {
+	Key       string   `json:"key"`
+	Hash      int      `json:"hash"`
+	Owner     int      `json:"owner"`
+	Replicas  []int    `json:"replicas"`
+	Addresses []string `json:"addresses"`
+}
+
+func (n *Node) Status() *NodeStatus {
+	status := &NodeStatus{
+		ID:         n.ID,
+		Address:    n.Address,
+		Version:    Version,
+		Started:    n.started,
+		Keys:       n.store.Size(),
+		Bytes:      n.storeBytes(),
+		Nodes:      n.registry.Size(),
+		Namespaces: len(n.namespaces.live()),
+		Sync:       make(map[string]map[string]float64),
+		Peers:      make([]PeerStatus, 0),
+	}
+	if !n.started.IsZero() {
+		status.Uptime = time.Since(n.started).Seconds()
+	}
+
+	for _, sample := range n.metrics.syncs.collect().Samples {
+		loop := sample.Labels["loop"]
+		if status.Sync[loop] == nil {
+			status.Sync[loop] = make(map[string]float64)
+		}
+		status.Sync[loop][sample.Labels["result"]] = sample.Value
+	}
+
+	for id, address := range n.registry.GetAll() {
+		if id == n.ID {
+			continue
+		}
+		peer := strconv.Itoa(id)
+		contact := n.peers.get(id)
+		status.Peers = append(status.Peers, PeerStatus{
+			ID:          id,
+			Address:     address,
+			Healthy:     !contact.lastFailure.After(contact.lastSuccess),
+			Requests:    n.metrics.peerRequests.get(peer),
+			Errors:      n.metrics.peerErrors.get(peer),
+			LastSuccess: contact.lastSuccess,
+			LastFailure: contact.lastFailure,
+			CircuitOpen: !n.peers.available(id),
+			Protocol:    n.protocolFor(id, true),
+		})
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].ID < status.Peers[j].ID })
+	return status
+}
+
+func (n *Node) Ring() []RingSegment {
+	nodes := n.registry.GetAll()
+	ids := make([]int, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	segments := make([]RingSegment, 0, len(ids))
+	for i, id := range ids {
+		segment := RingSegment{ID: id, Address: nodes[id], Token: id, Start: id + 1, End: ringSize - 1}
+		if i == 0 {
+			segment.Start = 0
+		}
+		if i+1 < len(ids) {
+			segment.End = ids[i+1]
+		}
+		segment.Percent = float64(segment.End-segment.Start+1) * 100 / ringSize
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+func (n *Node) Locate(key string) *KeyLocation {
+	ns, k := splitNamespaceKey(key)
+	location := &KeyLocation{
+		Key:       displayKey(ns, k),
+		Hash:      hash(key),
+		Owner:     n.bestMatch(key, []int{}),
+		Replicas:  n.bestMatches(key, n.replicasFor(key)+1, []int{}),
+		Addresses: make([]string, 0),
+	}
+	for _, id := range location.Replicas {
+		location.Addresses = append(location.Addresses, n.registry.Get(id))
+	}
+	return location
+}
+
+func (n *Node) authorizeAdmin(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	principal, _ := request.Attribute(principalAttribute).(string)
+	authorizer := n.authorization()
+	if principal == peerPrincipal || authorizer == nil || authorizer.Authorize(principal, adminPath, false) {
+		chain.ProcessFilter(request, response)
+		return
+	}
+	n.logger.Warn("denied access to admin endpoint", F("principal", principal))
+	response.WriteErrorString(http.StatusForbidden, "access to admin endpoints denied")
+}
+
+func (n *Node) getStatus(request *restful.Request, response *restful.Response) {
+	response.WriteEntity(n.Status())
+}
+
+func (n *Node) getRing(request *restful.Request, response *restful.Response) {
+	response.WriteEntity(n.Ring())
+}
+
+func (n *Node) locateKey(request *restful.Request, response *restful.Response) {
+	key := request.QueryParameter(keyPath)
+	if key == "" {
+		response.WriteErrorString(http.StatusBadRequest, "key is required")
+		return
+	}
+	if ns := request.QueryParameter(namespaceParam); ns != "" {
+		if _, found := n.namespaces.get(ns); !found {
+			response.WriteErrorString(http.StatusNotFound, "namespace not found")
+			return
+		}
+		key = namespaceKey(ns, key)
+	}
+	response.WriteEntity(n.Locate(key))
+}
@@ -0,0 +1,439 @@
+package corduroy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const batchGetPath = "/entities:batchGet"
+const batchPutPath = "/entities:batchPut"
+
+type BatchEntry struct {
+	Key         string
+	Value       string
+	ContentType string
+	Meta        map[string]string
+	TTL         time.Duration
+	IfMatch     string
+	IfNoneMatch string
+}
+
+type BatchResult struct {
+	Key         string            `json:"key"`
+	Status      int               `json:"status"`
+	Value       []byte            `json:"-"`
+	ContentType string            `json:"contentType,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	ETag        string            `json:"etag,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+type batchItem struct {
+	Key         string            `json:"key"`
+	Value       []byte            `json:"-"`
+	ContentType string            `json:"contentType,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	TTL         string            `json:"ttl,omitempty"`
+	Expires     string            `json:"expires,omitempty"`
+	Version     uint64            `json:"version,omitempty"`
+	Hops        int               `json:"hops,omitempty"`
+	IfMatch     string            `json:"ifMatch,omitempty"`
+	IfNoneMatch string            `json:"ifNoneMatch,omitempty"`
+}
+
+type batchRequest struct {
+	Keys    []string    `json:"keys,omitempty"`
+	Entries []batchItem `json:"entries,omitempty"`
+}
+
+type batchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+func (n *Node) BatchGet(keys []string) []BatchResult {
+	return n.batchGet(context.Background(), keys)
+}
+
+func (n *Node) BatchPut(entries []BatchEntry) []BatchResult {
+	items := make([]batchItem, len(entries))
+	for i, entry := range entries {
+		items[i] = batchItem{
+			Key:         entry.Key,
+			Value:       []byte(entry.Value),
+			ContentType: entry.ContentType,
+			Meta:        entry.Meta,
+			IfMatch:     entry.IfMatch,
+			IfNoneMatch: entry.IfNoneMatch,
+		}
+		if entry.TTL > 0 {
+			items[i].Expires = formatExpiry(time.Now().Add(entry.TTL))
+		}
+	}
+	return n.batchPut(context.Background(), items)
+}
+
+func (n *Node) groupByOwner(keys []string, match func(string, []int) int) map[int][]int {
+	groups := make(map[int][]int)
+	for i, key := range keys {
+		owner := match(key, []int{})
+		if owner < 0 {
+			owner = n.ID
+		}
+		groups[owner] = append(groups[owner], i)
+	}
+	return groups
+}
+
+func (n *Node) batchGet(ctx context.Context, keys []string) []BatchResult {
+	results := make([]BatchResult, len(keys))
+	var wg sync.WaitGroup
+	for owner, indexes := range n.groupByOwner(keys, n.availableMatch) {
+		wg.Add(1)
+		go func(owner int, indexes []int) {
+			defer wg.Done()
+			sub := make([]string, len(indexes))
+			for i, index := range indexes {
+				sub[i] = keys[index]
+			}
+
+			var subResults []BatchResult
+			if owner == n.ID {
+				subResults = n.batchGetLocal(sub)
+			} else {
+				subResults = n.batchRemote(ctx, n.registry.Get(owner), batchGetPath, &batchRequest{Keys: sub}, sub, []int{n.ID})
+			}
+			for i, index := range indexes {
+				results[index] = subResults[i]
+			}
+		}(owner, indexes)
+	}
+	wg.Wait()
+	return results
+}
+
+func (n *Node) batchGetLocal(keys []string) []BatchResult {
+	results := make([]BatchResult, len(keys))
+	for i, key := range keys {
+		entry, found := n.lookup(key)
+		if !found {
+			results[i] = BatchResult{Key: key, Status: http.StatusNotFound}
+			continue
+		}
+		entry, err := decodeEntry(entry)
+		if err != nil {
+			results[i] = BatchResult{Key: key, Status: http.StatusInternalServerError, Error: err.Error()}
+			continue
+		}
+		if isManifest(entry.Value) {
+			results[i] = BatchResult{Key: key, Status: http.StatusRequestEntityTooLarge, Error: ErrChunkedValue.Error(), ETag: formatETag(entry.Version)}
+			continue
+		}
+		results[i] = BatchResult{Key: key, Status: http.StatusOK, Value: append([]byte(nil), entry.Value...), ContentType: entry.ContentType, Meta: entry.Meta, ETag: formatETag(entry.Version)}
+	}
+	n.logger.Debug("retrieved batch", F("keys", len(keys)))
+	return results
+}
+
+func (n *Node) batchPut(ctx context.Context, items []batchItem) []BatchResult {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+
+	results := make([]BatchResult, len(items))
+	var wg sync.WaitGroup
+	for owner, indexes := range n.groupByOwner(keys, n.bestMatch) {
+		wg.Add(1)
+		go func(owner int, indexes []int) {
+			defer wg.Done()
+			sub := make([]batchItem, len(indexes))
+			subKeys := make([]string, len(indexes))
+			for i, index := range indexes {
+				sub[i] = items[index]
+				subKeys[i] = items[index].Key
+			}
+
+			var subResults []BatchResult
+			if owner == n.ID {
+				subResults = n.batchPutLocal(ctx, sub, []int{})
+			} else {
+				subResults = n.batchRemote(ctx, n.registry.Get(owner), batchPutPath, &batchRequest{Entries: sub}, subKeys, []int{n.ID})
+			}
+			for i, index := range indexes {
+				results[index] = subResults[i]
+			}
+		}(owner, indexes)
+	}
+	wg.Wait()
+	return results
+}
+
+func (n *Node) batchPutLocal(ctx context.Context, items []batchItem, visited []int) []BatchResult {
+	results := make([]BatchResult, len(items))
+	replicas := make([]batchItem, 0, len(items))
+	positions := make([]int, 0, len(items))
+	for i, item := range items {
+		results[i] = BatchResult{Key: item.Key}
+		expiry, err := parseTTL(item.TTL)
+		if item.Expires != "" {
+			expiry, err = time.Parse(time.RFC3339Nano, item.Expires)
+		}
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+			continue
+		}
+		entry := &Entry{Value: item.Value, Expiry: expiry, ContentType: item.ContentType, Meta: item.Meta}
+
+		hops := item.Hops
+		if item.Version > 0 {
+			entry.Version = item.Version
+			if n.applyEntry(item.Key, entry) {
+				n.observeReplication(entry.Version)
+			}
+		} else {
+			hops = n.replicasFor(item.Key)
+			if isManifest(item.Value) {
+				results[i].Status = http.StatusBadRequest
+				results[i].Error = ErrReservedValue.Error()
+				continue
+			}
+			if int64(len(item.Value)) > n.maxObjectSize {
+				results[i].Status = http.StatusRequestEntityTooLarge
+				results[i].Error = ErrObjectTooLarge.Error()
+				continue
+			}
+			stored := n.compressEntry(item.Key, entry)
+			name, _ := splitNamespaceKey(item.Key)
+			if ns, found := n.namespaces.get(name); found {
+				statusCode, err := n.checkNamespaceLimits(ns, item.Key, stored.Value)
+				if err != nil {
+					results[i].Status = statusCode
+					results[i].Error = err.Error()
+					continue
+				}
+			}
+			err = n.commitEntry(item.Key, stored, item.IfMatch, item.IfNoneMatch)
+			if err != nil {
+				results[i].Status = http.StatusPreconditionFailed
+				results[i].Error = err.Error()
+				continue
+			}
+			entry.Version = stored.Version
+		}
+
+		results[i].Status = http.StatusOK
+		results[i].ETag = formatETag(entry.Version)
+		if hops > 0 {
+			replica := batchItem{Key: item.Key, Value: entry.Value, ContentType: entry.ContentType, Meta: entry.Meta, Version: entry.Version, Hops: hops - 1}
+			if !entry.Expiry.IsZero() {
+				replica.Expires = formatExpiry(entry.Expiry)
+			}
+			replicas = append(replicas, replica)
+			positions = append(positions, i)
+		}
+	}
+	n.logger.Debug("wrote batch", F("keys", len(items)))
+
+	if len(replicas) > 0 {
+		n.replicateBatch(ctx, replicas, positions, results, append(visited, n.ID))
+	}
+	return results
+}
+
+func (n *Node) replicateBatch(ctx context.Context, replicas []batchItem, positions []int, results []BatchResult, visited []int) {
+	groups := make(map[int][]int)
+	for i, replica := range replicas {
+		next := n.availableMatch(replica.Key, visited)
+		if next >= 0 {
+			groups[next] = append(groups[next], i)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for next, indexes := range groups {
+		wg.Add(1)
+		go func(next int, indexes []int) {
+			defer wg.Done()
+			sub := make([]batchItem, len(indexes))
+			subKeys := make([]string, len(indexes))
+			for i, index := range indexes {
+				sub[i] = replicas[index]
+				subKeys[i] = replicas[index].Key
+			}
+
+			subResults := n.batchRemote(ctx, n.registry.Get(next), batchPutPath, &batchRequest{Entries: sub}, subKeys, visited)
+			for i, index := range indexes {
+				if subResults[i].Status != http.StatusOK {
+					results[positions[index]].Status = subResults[i].Status
+					results[positions[index]].Error = subResults[i].Error
+				}
+			}
+		}(next, indexes)
+	}
+	wg.Wait()
+}
+
+func (n *Node) batchRemote(ctx context.Context, address string, path string, batch *batchRequest, keys []string, visited []int) []BatchResult {
+	uri := address + path
+	n.logger.Debug("sending batch", F("keys", len(keys)), F(peerField, uri))
+	results, err := n.sendBatch(ctx, uri, batch, visited, len(keys))
+	if err != nil {
+		n.logger.Warn("unable to send batch", F(peerField, uri), F(errorField, err))
+		results = make([]BatchResult, len(keys))
+		for i, key := range keys {
+			results[i] = BatchResult{Key: key, Status: http.StatusBadGateway, Error: err.Error()}
+		}
+	}
+	return results
+}
+
+func (n *Node) sendBatch(ctx context.Context, uri string, batch *batchRequest, visited []int, expected int) ([]BatchResult, error) {
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := n.bulkContext(ctx)
+	defer cancel()
+	statusCode, body, err := n.send(ctx, "POST", uri, string(b), buildPeerHeader(visited, 0))
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code '%d'", statusCode)
+	}
+
+	response := &batchResponse{}
+	err = json.Unmarshal([]byte(body), response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Results) != expected {
+		return nil, fmt.Errorf("expected '%d' results but received '%d'", expected, len(response.Results))
+	}
+	return response.Results, nil
+}
+
+func (n *Node) allowed(request *restful.Request, key string, write bool) bool {
+	principal, _ := request.Attribute(principalAttribute).(string)
+	authorizer := n.authorization()
+	if principal == peerPrincipal || authorizer == nil {
+		return true
+	}
+	return authorizer.Authorize(principal, key, write)
+}
+
+func (n *Node) batchGetValues(request *restful.Request, response *restful.Response) {
+	n.serveBatchGet(request, response, defaultNamespace)
+}
+
+func (n *Node) batchPutValues(request *restful.Request, response *restful.Response) {
+	n.serveBatchPut(request, response, defaultNamespace)
+}
+
+func (n *Node) batchGetNamespacedValues(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	n.serveBatchGet(request, response, ns.Name)
+}
+
+func (n *Node) batchPutNamespacedValues(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	n.serveBatchPut(request, response, ns.Name)
+}
+
+func (n *Node) serveBatchGet(request *restful.Request, response *restful.Response, ns string) {
+	batch := &batchRequest{}
+	err := request.ReadEntity(batch)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	if n.isPeer(request) {
+		response.WriteEntity(&batchResponse{Results: n.batchGetLocal(batch.Keys)})
+		return
+	}
+
+	results := make([]BatchResult, len(batch.Keys))
+	keys := make([]string, 0, len(batch.Keys))
+	positions := make([]int, 0, len(batch.Keys))
+	for i, key := range batch.Keys {
+		if err := validateKey(key); err != nil {
+			results[i] = BatchResult{Key: key, Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		if !n.allowed(request, displayKey(ns, key), false) {
+			results[i] = BatchResult{Key: key, Status: http.StatusForbidden, Error: "access to key denied"}
+			continue
+		}
+		keys = append(keys, namespaceKey(ns, key))
+		positions = append(positions, i)
+	}
+
+	for i, result := range n.batchGet(request.Request.Context(), keys) {
+		result.Key = batch.Keys[positions[i]]
+		results[positions[i]] = result
+	}
+	response.WriteEntity(&batchResponse{Results: results})
+}
+
+func (n *Node) serveBatchPut(request *restful.Request, response *restful.Response, ns string) {
+	batch := &batchRequest{}
+	err := request.ReadEntity(batch)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	if n.isPeer(request) {
+		visited, _ := parseVisited(&request.Request.Header)
+		response.WriteEntity(&batchResponse{Results: n.batchPutLocal(request.Request.Context(), batch.Entries, visited)})
+		return
+	}
+
+	results := make([]BatchResult, len(batch.Entries))
+	items := make([]batchItem, 0, len(batch.Entries))
+	positions := make([]int, 0, len(batch.Entries))
+	for i, item := range batch.Entries {
+		if err := validateKey(item.Key); err != nil {
+			results[i] = BatchResult{Key: item.Key, Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		if !n.allowed(request, displayKey(ns, item.Key), true) {
+			results[i] = BatchResult{Key: item.Key, Status: http.StatusForbidden, Error: "access to key denied"}
+			continue
+		}
+		item.Key = namespaceKey(ns, item.Key)
+		item.Version = 0
+		item.Hops = 0
+		if item.TTL != "" {
+			expiry, err := parseTTL(item.TTL)
+			if err != nil {
+				results[i] = BatchResult{Key: batch.Entries[i].Key, Status: http.StatusBadRequest, Error: err.Error()}
+				continue
+			}
+			item.TTL = ""
+			item.Expires = formatExpiry(expiry)
+		}
+		items = append(items, item)
+		positions = append(positions, i)
+	}
+
+	for i, result := range n.batchPut(request.Request.Context(), items) {
+		result.Key = batch.Entries[positions[i]].Key
+		results[positions[i]] = result
+	}
+	response.WriteEntity(&batchResponse{Results: results})
+}
@@ -0,0 +1,132 @@
+package corduroy
+
+import (
+	"context"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const cachePath = "/cache"
+const cacheHeader = "X-Corduroy-Cache"
+
+const defaultCacheTTL = time.Second
+
+const cacheHit = "hit"
+const cacheMiss = "miss"
+
+type CacheOptions struct {
+	Size int
+	TTL  time.Duration
+}
+
+func (n *Node) UseCache(options *CacheOptions) {
+	if options == nil {
+		n.cache.configure(0, 0)
+		return
+	}
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	n.cache.configure(options.Size, ttl)
+}
+
+func (n *Node) cachedValue(key string) (*Entry, bool) {
+	if _, enabled := n.cache.enabled(); !enabled {
+		return nil, false
+	}
+	entry, found := n.cache.get(key, time.Now())
+	if found {
+		n.metrics.cacheRequests.add(1, cacheHit)
+	} else {
+		n.metrics.cacheRequests.add(1, cacheMiss)
+	}
+	return entry, found
+}
+
+func (n *Node) cacheValue(key string, body string, header http.Header) {
+	if _, enabled := n.cache.enabled(); !enabled {
+		return
+	}
+	version, err := parseETag(header.Get("ETag"))
+	if err != nil {
+		return
+	}
+	entry := &Entry{Value: []byte(body), Version: version}
+	entry.ContentType, entry.Meta = readContentHeaders(header)
+	entry.Codec = header.Get("Content-Encoding")
+	if e := header.Get(expiresHeader); e != "" {
+		entry.Expiry, err = time.Parse(time.RFC3339Nano, e)
+		if err != nil {
+			return
+		}
+	}
+	n.cache.put(key, entry, time.Now())
+}
+
+func (n *Node) requestCaching(request *restful.Request, header http.Header) bool {
+	if ttl, enabled := n.cache.enabled(); enabled {
+		header.Set(cacheHeader, ttl.String())
+		return true
+	}
+	if ttl := request.HeaderParameter(cacheHeader); ttl != "" {
+		header.Set(cacheHeader, ttl)
+	}
+	return false
+}
+
+func (n *Node) recordReaders(request *restful.Request, key string) {
+	ttl, err := time.ParseDuration(request.HeaderParameter(cacheHeader))
+	if err != nil || ttl <= 0 {
+		return
+	}
+	visited, _ := parseVisited(&request.Request.Header)
+	ids := make([]int, 0, len(visited))
+	for _, id := range visited {
+		if id != n.ID && n.registry.Contains(id) {
+			ids = append(ids, id)
+		}
+	}
+	n.readers.record(key, ids, time.Now().Add(ttl))
+}
+
+func (n *Node) invalidate(key string) {
+	n.cache.remove(key)
+	for _, id := range n.readers.take(key, time.Now()) {
+		address := n.registry.Get(id)
+		if address == "" {
+			continue
+		}
+		go func(address string) {
+			err := n.invalidateRemote(context.Background(), address, key)
+			if err != nil {
+				n.logger.Debug("unable to invalidate cached value", F(peerField, address), F(keyField, key), F(errorField, err))
+			}
+		}(address)
+	}
+}
+
+func (n *Node) invalidateCache(request *restful.Request, response *restful.Response) {
+	key := request.QueryParameter(keyPath)
+	if n.cache.remove(key) {
+		n.metrics.cacheInvalidations.add(1)
+		n.logger.Debug("invalidated cached value", F(keyField, key))
+	}
+	response.WriteHeader(http.StatusOK)
+}
+
+func (n *Node) invalidateRemote(ctx context.Context, address string, key string) error {
+	uri := address + cachePath + "?" + keyPath + "=" + url.QueryEscape(key)
+	n.logger.Debug("sending invalidate request", F(peerField, uri))
+	statusCode, _, err := n.send(ctx, "DELETE", uri, "", buildPeerHeader([]int{n.ID}, 0))
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code '%d' invalidating '%s' on '%s'", statusCode, key, address)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package corduroy
+
+import (
+	"encoding/json"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"strconv"
+)
+
+const changesPath = "/changes"
+const followParam = "follow"
+
+func (n *Node) UseChangeLog(path string, retention ChangeRetention) error {
+	if path == "" {
+		n.changes = newChangeLog(retention)
+		n.changes.logger = n.logger
+		return nil
+	}
+	changes, err := openChangeLog(path, retention, n.logger)
+	if err != nil {
+		return err
+	}
+	n.changes = changes
+	return nil
+}
+
+func (n *Node) Changes(since uint64, limit int) ([]*Change, error) {
+	changes, _, err := n.changes.read(since, limit)
+	return changes, err
+}
+
+func (n *Node) getChanges(request *restful.Request, response *restful.Response) {
+	var since uint64
+	if s := request.QueryParameter(sinceParam); s != "" {
+		parsed, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			response.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		since = parsed
+	}
+	limit := 0
+	if l := request.QueryParameter(limitParam); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 0 {
+			response.WriteErrorString(http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = parsed
+	}
+	follow := request.QueryParameter(followParam) == "true"
+
+	changes, appended, err := n.changes.read(since, limit)
+	if err == ErrChangesCompacted {
+		response.WriteError(http.StatusGone, err)
+		return
+	}
+
+	response.AddHeader("Content-Type", ndjsonMime)
+	response.WriteHeader(http.StatusOK)
+	flusher, _ := response.ResponseWriter.(http.Flusher)
+	encoder := json.NewEncoder(response)
+	ctx := request.Request.Context()
+	sent := 0
+	for {
+		for _, change := range changes {
+			since = change.Sequence
+			if !n.allowed(request, displayKey(change.Namespace, change.Key), false) {
+				continue
+			}
+			err = encoder.Encode(change)
+			if err != nil {
+				n.logger.Debug("unable to send change", F("sequence", change.Sequence), F(errorField, err))
+				return
+			}
+			sent++
+			if limit > 0 && sent >= limit {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !follow {
+			return
+		}
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return
+		case <-n.done:
+			return
+		}
+		remaining := 0
+		if limit > 0 {
+			remaining = limit - sent
+		}
+		changes, appended, err = n.changes.read(since, remaining)
+		if err != nil {
+			encoder.Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package corduroy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const chunkNamespace = "_chunks"
+const manifestPrefix = "\x00corduroy-manifest\x00"
+const sizeLimitHeader = "X-Corduroy-Size-Limit"
+
+const defaultChunkSize = 1 << 20
+const defaultMaxObjectSize = 1 << 30
+
+var ErrNotFound = errors.New("key not found")
+var ErrObjectTooLarge = errors.New("value exceeds the maximum object size")
+var ErrReservedValue = errors.New("values may not begin with the reserved manifest marker")
+var ErrChunkedValue = errors.New("value is stored in chunks and must be read on its own")
+
+type ChunkOptions struct {
+	ChunkSize     int
+	MaxObjectSize int64
+}
+
+type manifest struct {
+	Size      int64    `json:"size"`
+	ChunkSize int      `json:"chunkSize"`
+	Chunks    []string `json:"chunks"`
+}
+
+func (n *Node) UseChunking(options *ChunkOptions) {
+	n.chunkSize = options.ChunkSize
+	if n.chunkSize <= 0 {
+		n.chunkSize = defaultChunkSize
+	}
+	n.maxObjectSize = options.MaxObjectSize
+	if n.maxObjectSize <= 0 {
+		n.maxObjectSize = defaultMaxObjectSize
+	}
+}
+
+func (n *Node) PutReader(key string, r io.Reader) error {
+	value, err := n.readValue(context.Background(), r, time.Time{}, n.maxObjectSize)
+	if err != nil {
+		return err
+	}
+	err = n.commitEntry(key, &Entry{Value: value}, "", "")
+	if err != nil {
+		n.releaseValue(value)
+	}
+	return err
+}
+
+func (n *Node) GetReader(key string) (io.ReadCloser, error) {
+	entry, found := n.lookup(key)
+	if !found {
+		return nil, ErrNotFound
+	}
+	entry, err := decodeEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	if !isManifest(entry.Value) {
+		return ioutil.NopCloser(bytes.NewReader(entry.Value)), nil
+	}
+	m, err := parseManifest(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	return n.chunkReader(context.Background(), m, 0, m.Size), nil
+}
+
+func isManifest(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(manifestPrefix))
+}
+
+func parseManifest(value []byte) (*manifest, error) {
+	m := &manifest{}
+	err := json.Unmarshal(bytes.TrimPrefix(value, []byte(manifestPrefix)), m)
+	if err != nil {
+		return nil, err
+	}
+	if m.ChunkSize <= 0 || int64(len(m.Chunks)) < (m.Size+int64(m.ChunkSize)-1)/int64(m.ChunkSize) {
+		return nil, errors.New("malformed manifest")
+	}
+	return m, nil
+}
+
+func (m *manifest) encode() []byte {
+	b, _ := json.Marshal(m)
+	return append([]byte(manifestPrefix), b...)
+}
+
+func chunkKey(upload string, index int) string {
+	return namespaceKey(chunkNamespace, upload+"."+strconv.Itoa(index))
+}
+
+func listedValue(value []byte) ([]byte, int64) {
+	if !isManifest(value) {
+		return append([]byte(nil), value...), int64(len(value))
+	}
+	m, err := parseManifest(value)
+	if err != nil {
+		return nil, 0
+	}
+	return nil, m.Size
+}
+
+func chunkOrigin(key string, origin string) string {
+	if name, _ := splitNamespaceKey(key); name == chunkNamespace {
+		return SystemOrigin
+	}
+	return origin
+}
+
+func (n *Node) readValue(ctx context.Context, r io.Reader, expiry time.Time, limit int64) ([]byte, error) {
+	m := &manifest{ChunkSize: n.chunkSize, Chunks: make([]string, 0)}
+	upload := strconv.FormatInt(time.Now().UnixNano(), 36) + strconv.FormatInt(rand.Int63(), 36)
+	var first []byte
+	buf := make([]byte, n.chunkSize)
+	for {
+		count, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			n.releaseChunks(m)
+			return nil, err
+		}
+		if count > 0 {
+			m.Size += int64(count)
+			if m.Size > limit {
+				n.releaseChunks(m)
+				return nil, ErrObjectTooLarge
+			}
+			if first == nil {
+				if isManifest(buf[:count]) {
+					return nil, ErrReservedValue
+				}
+				first = buf[:count]
+				buf = make([]byte, n.chunkSize)
+			} else {
+				if len(m.Chunks) == 0 {
+					err := n.appendChunk(ctx, m, upload, first, expiry)
+					if err != nil {
+						n.releaseChunks(m)
+						return nil, err
+					}
+				}
+				err := n.appendChunk(ctx, m, upload, buf[:count], expiry)
+				if err != nil {
+					n.releaseChunks(m)
+					return nil, err
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if len(m.Chunks) == 0 {
+		return first, nil
+	}
+	n.logger.Debug("stored chunked value", F("size", m.Size), F("chunks", len(m.Chunks)))
+	return m.encode(), nil
+}
+
+func (n *Node) appendChunk(ctx context.Context, m *manifest, upload string, b []byte, expiry time.Time) error {
+	key := chunkKey(upload, len(m.Chunks))
+	m.Chunks = append(m.Chunks, key)
+	address := n.registry.Get(n.availableMatch(key, []int{}))
+	statusCode, body, err := n.putEntryRemote(ctx, address, key, &Entry{Value: b, Expiry: expiry}, []int{n.ID}, n.replicasFor(key))
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code '%d' storing chunk on '%s', %s", statusCode, address, body)
+	}
+	return nil
+}
+
+func (n *Node) getChunk(ctx context.Context, key string) ([]byte, error) {
+	if entry, found := n.lookup(key); found {
+		entry, err := decodeEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		return entry.Value, nil
+	}
+	owner := n.availableMatch(key, []int{n.ID})
+	if owner < 0 {
+		return nil, fmt.Errorf("chunk '%s' not found", key)
+	}
+	address := n.registry.Get(owner)
+	statusCode, body, err := n.getValueRemote(ctx, address, key, []int{n.ID}, n.replicasFor(key))
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code '%d' reading chunk '%s' from '%s'", statusCode, key, address)
+	}
+	return []byte(body), nil
+}
+
+func (n *Node) releaseValue(value []byte) {
+	if !isManifest(value) {
+		return
+	}
+	m, err := parseManifest(value)
+	if err != nil {
+		n.logger.Warn("unable to release chunks of malformed manifest", F(errorField, err))
+		return
+	}
+	n.releaseChunks(m)
+}
+
+func (n *Node) releaseChunks(m *manifest) {
+	if len(m.Chunks) == 0 {
+		return
+	}
+	go func(chunks []string) {
+		for _, key := range chunks {
+			n.deleteEntry(key, SystemOrigin)
+			address := n.registry.Get(n.availableMatch(key, []int{}))
+			_, _, err := n.deleteValueRemote(context.Background(), address, key, []int{n.ID}, n.replicasFor(key), 0)
+			if err != nil {
+				n.logger.Warn("unable to delete chunk", F(keyField, key), F(peerField, address), F(errorField, err))
+			}
+		}
+	}(m.Chunks)
+}
+
+func (n *Node) replaced(key string, previous *Entry, next *Entry) {
+	if previous == nil || !isManifest(previous.Value) || (next != nil && bytes.Equal(next.Value, previous.Value)) {
+		return
+	}
+	if n.bestMatch(key, []int{}) == n.ID {
+		n.releaseValue(previous.Value)
+	}
+}
+
+func (n *Node) readClientValue(request *restful.Request, ns *Namespace, expiry time.Time) ([]byte, error) {
+	limit := n.maxObjectSize
+	if ns != nil && ns.MaxValueBytes > 0 && int64(ns.MaxValueBytes) < limit {
+		limit = int64(ns.MaxValueBytes)
+	}
+	if request.Request.ContentLength > limit {
+		return nil, ErrObjectTooLarge
+	}
+	return n.readValue(request.Request.Context(), request.Request.Body, expiry, limit)
+}
+
+func (n *Node) writeContent(request *restful.Request, response *restful.Response, value []byte) {
+	size := int64(len(value))
+	var m *manifest
+	if isManifest(value) {
+		var err error
+		m, err = parseManifest(value)
+		if err != nil {
+			response.WriteError(http.StatusInternalServerError, err)
+			return
+		}
+		size = m.Size
+	}
+	n.writeStream(request, response, size, func(start int64, end int64) (io.Reader, error) {
+		if m != nil {
+			return n.chunkReader(request.Request.Context(), m, start, end), nil
+		}
+		return bytes.NewReader(value[start:end]), nil
+	})
+}
+
+func (n *Node) streamValue(request *restful.Request, response *restful.Response, uri string, header http.Header) {
+	remote, err := n.openWith(request.Request.Context(), uri, header)
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	defer remote.Body.Close()
+	copyHeaders(response.Header(), remote.Header, "ETag", expiresHeader)
+	if remote.StatusCode != http.StatusOK {
+		response.WriteHeader(remote.StatusCode)
+		return
+	}
+	if version, err := parseETag(remote.Header.Get("ETag")); err == nil && matchesETag(request.HeaderParameter("If-None-Match"), version, true) {
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+	copyContentHeaders(response.Header(), remote.Header)
+	if remote.ContentLength < 0 {
+		b, err := ioutil.ReadAll(remote.Body)
+		if err != nil {
+			response.WriteError(http.StatusBadGateway, err)
+			return
+		}
+		n.writeContent(request, response, b)
+		return
+	}
+	n.writeStream(request, response, remote.ContentLength, func(start int64, end int64) (io.Reader, error) {
+		_, err := io.CopyN(ioutil.Discard, remote.Body, start)
+		return io.LimitReader(remote.Body, end-start), err
+	})
+}
+
+func (n *Node) writeStream(request *restful.Request, response *restful.Response, size int64, open func(int64, int64) (io.Reader, error)) {
+	response.AddHeader("Accept-Ranges", "bytes")
+	start, end, partial, err := parseRange(request.HeaderParameter("Range"), size)
+	if err != nil {
+		response.AddHeader("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		response.WriteErrorString(http.StatusRequestedRangeNotSatisfiable, err.Error())
+		return
+	}
+	reader, err := open(start, end)
+	if err != nil {
+		response.WriteError(http.StatusBadGateway, err)
+		return
+	}
+
+	response.AddHeader("Content-Length", strconv.FormatInt(end-start, 10))
+	if partial {
+		response.AddHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		response.WriteHeader(http.StatusPartialContent)
+	} else {
+		response.WriteHeader(http.StatusOK)
+	}
+	_, err = io.Copy(response, reader)
+	if err != nil {
+		n.logger.Warn("unable to stream value", F(errorField, err))
+	}
+}
+
+type chunkReader struct {
+	ctx      context.Context
+	node     *Node
+	manifest *manifest
+	offset   int64
+	end      int64
+	current  []byte
+}
+
+func (n *Node) chunkReader(ctx context.Context, m *manifest, start int64, end int64) *chunkReader {
+	return &chunkReader{ctx: ctx, node: n, manifest: m, offset: start, end: end}
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.current) == 0 {
+		if cr.offset >= cr.end {
+			return 0, io.EOF
+		}
+		size := int64(cr.manifest.ChunkSize)
+		key := cr.manifest.Chunks[cr.offset/size]
+		value, err := cr.node.getChunk(cr.ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		skip := cr.offset % size
+		if int64(len(value)) <= skip {
+			return 0, fmt.Errorf("chunk '%s' is shorter than its manifest", key)
+		}
+		b := value[skip:]
+		if remaining := cr.end - cr.offset; int64(len(b)) > remaining {
+			b = b[:remaining]
+		}
+		cr.current = b
+	}
+	count := copy(p, cr.current)
+	cr.current = cr.current[count:]
+	cr.offset += int64(count)
+	return count, nil
+}
+
+func (cr *chunkReader) Close() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package corduroy
+
+import (
+	"context"
+	"net/http"
+)
+
+const coalescedPeer = "peer"
+const coalescedStore = "store"
+
+type forwardResult struct {
+	statusCode int
+	body       string
+	header     http.Header
+}
+
+func (n *Node) UseStoreCoalescing(enabled bool) {
+	n.coalesceStore = enabled
+}
+
+func (n *Node) forwardGet(ctx context.Context, uri string, header http.Header) (int, string, http.Header, error) {
+	key := uri + "\xff" + header.Get(hopsHeader) + "\xff" + header.Get("If-None-Match")
+	value, shared, err := n.flights.do(ctx, "GET\xff"+key, func() (interface{}, error) {
+		bounded, cancel := context.WithTimeout(detach(ctx), n.peerOptions.Timeout)
+		defer cancel()
+		statusCode, body, responseHeader, err := n.exchange(bounded, "GET", uri, "", header)
+		return &forwardResult{statusCode: statusCode, body: body, header: responseHeader}, err
+	})
+	if shared {
+		n.metrics.coalesced.add(1, coalescedPeer)
+	}
+	if err != nil {
+		return 0, "", nil, err
+	}
+	result := value.(*forwardResult)
+	return result.statusCode, result.body, result.header.Clone(), nil
+}
+
+func (n *Node) lookupShared(ctx context.Context, key string) (*Entry, bool) {
+	if !n.coalesceStore {
+		return n.lookup(key)
+	}
+	value, shared, err := n.flights.do(ctx, "STORE\xff"+key, func() (interface{}, error) {
+		entry, _ := n.lookup(key)
+		return entry, nil
+	})
+	if shared {
+		n.metrics.coalesced.add(1, coalescedStore)
+	}
+	entry, _ := value.(*Entry)
+	return entry, err == nil && entry != nil
+}
@@ -0,0 +1,159 @@
+package corduroy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+const gzipCodec = restful.ENCODING_GZIP
+const codecProtocol = 3
+
+const defaultCompressMinSize = 1024
+
+const compressIn = "in"
+const compressOut = "out"
+
+type CompressionOptions struct {
+	MinSize int
+}
+
+func (n *Node) UseCompression(options *CompressionOptions) {
+	if options == nil {
+		n.compressAll = false
+		n.compressMinSize = defaultCompressMinSize
+		return
+	}
+	n.compressAll = true
+	n.compressMinSize = options.MinSize
+	if n.compressMinSize <= 0 {
+		n.compressMinSize = defaultCompressMinSize
+	}
+}
+
+func (n *Node) compresses(key string) bool {
+	if n.compressAll {
+		return true
+	}
+	name, _ := splitNamespaceKey(key)
+	ns, found := n.namespaces.get(name)
+	return found && ns.Compress
+}
+
+func (n *Node) compressEntry(key string, entry *Entry) *Entry {
+	if entry.Codec != "" || len(entry.Value) < n.compressMinSize || isManifest(entry.Value) || !n.compresses(key) {
+		return entry
+	}
+	value, err := compressValue(entry.Value)
+	if err != nil {
+		n.logger.Warn("unable to compress value", F(keyField, key), F(errorField, err))
+		return entry
+	}
+	n.metrics.compression.add(float64(len(entry.Value)), compressIn)
+	if len(value) >= len(entry.Value) {
+		n.metrics.compression.add(float64(len(entry.Value)), compressOut)
+		return entry
+	}
+	n.metrics.compression.add(float64(len(value)), compressOut)
+	compressed := *entry
+	compressed.Value = value
+	compressed.Codec = gzipCodec
+	return &compressed
+}
+
+func decodeEntry(entry *Entry) (*Entry, error) {
+	if entry.Codec == "" {
+		return entry, nil
+	}
+	if entry.Codec != gzipCodec {
+		return nil, fmt.Errorf("unsupported codec '%s'", entry.Codec)
+	}
+	value, err := decompressValue(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	plain := *entry
+	plain.Value = value
+	plain.Codec = ""
+	return &plain, nil
+}
+
+func compressValue(value []byte) ([]byte, error) {
+	provider := restful.CurrentCompressorProvider()
+	writer := provider.AcquireGzipWriter()
+	defer provider.ReleaseGzipWriter(writer)
+	var buf bytes.Buffer
+	writer.Reset(&buf)
+	_, err := writer.Write(value)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressValue(value []byte) ([]byte, error) {
+	provider := restful.CurrentCompressorProvider()
+	reader := provider.AcquireGzipReader()
+	defer provider.ReleaseGzipReader(reader)
+	err := reader.Reset(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func (n *Node) peerEntry(uri string, entry *Entry, header http.Header) (*Entry, error) {
+	if entry.Codec == "" {
+		return entry, nil
+	}
+	if n.peerProtocol(uri) >= codecProtocol {
+		header.Set("Content-Encoding", entry.Codec)
+		return entry, nil
+	}
+	return decodeEntry(entry)
+}
+
+func (n *Node) writeValue(request *restful.Request, response *restful.Response, entry *Entry) {
+	peer := n.isPeer(request)
+	if entry.Codec != "" {
+		response.AddHeader("Vary", "Accept-Encoding")
+		if acceptsEncoding(request.HeaderParameter("Accept-Encoding"), entry.Codec) && (peer || request.HeaderParameter("Range") == "") {
+			response.AddHeader("Content-Encoding", entry.Codec)
+		} else {
+			plain, err := decodeEntry(entry)
+			if err != nil {
+				response.WriteError(http.StatusInternalServerError, err)
+				return
+			}
+			entry = plain
+		}
+	}
+	if peer {
+		size := strconv.Itoa(len(entry.Value))
+		if limit, err := strconv.Atoi(request.HeaderParameter(sizeLimitHeader)); err == nil && len(entry.Value) > limit {
+			response.AddHeader(sizeLimitHeader, size)
+			response.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		response.AddHeader("Content-Length", size)
+		response.WriteHeader(http.StatusOK)
+		response.Write(entry.Value)
+		return
+	}
+	n.writeContent(request, response, entry.Value)
+}
+
+func (n *Node) compressionRatio() float64 {
+	in := n.metrics.compression.get(compressIn)
+	if in == 0 {
+		return 0
+	}
+	return n.metrics.compression.get(compressOut) / in
+}
@@ -0,0 +1,176 @@
+package corduroy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const healthzPath = "/healthz"
+const readyzPath = "/readyz"
+const handoffPath = "/handoff"
+
+type HealthChecker interface {
+	Healthy() error
+}
+
+type Readiness struct {
+	Ready     bool   `json:"ready"`
+	Joined    bool   `json:"joined"`
+	HandedOff bool   `json:"handedOff"`
+	Draining  bool   `json:"draining"`
+	Store     string `json:"store"`
+}
+
+type handoffItem struct {
+	Key         string            `json:"key"`
+	Value       []byte            `json:"-"`
+	ContentType string            `json:"contentType,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Version     uint64            `json:"version"`
+	Expiry      time.Time         `json:"expiry"`
+}
+
+type nodeState struct {
+	joined    bool
+	handedOff bool
+	draining  bool
+	mux       sync.Mutex
+}
+
+func (ns *nodeState) set(update func(*nodeState)) {
+	ns.mux.Lock()
+	defer ns.mux.Unlock()
+	update(ns)
+}
+
+func (ns *nodeState) get() (bool, bool, bool) {
+	ns.mux.Lock()
+	defer ns.mux.Unlock()
+	return ns.joined, ns.handedOff, ns.draining
+}
+
+func (n *Node) Readiness() *Readiness {
+	joined, handedOff, draining := n.state.get()
+	n.seedsMux.Lock()
+	standalone := len(n.seeds) == 0
+	n.seedsMux.Unlock()
+	if standalone {
+		joined, handedOff = true, true
+	}
+
+	readiness := &Readiness{Joined: joined, HandedOff: handedOff, Draining: draining, Store: "ok"}
+	if checker, ok := n.store.(HealthChecker); ok {
+		if err := checker.Healthy(); err != nil {
+			readiness.Store = err.Error()
+		}
+	}
+	readiness.Ready = !n.started.IsZero() && joined && handedOff && !draining && readiness.Store == "ok"
+	return readiness
+}
+
+func (n *Node) Drain() {
+	n.state.set(func(ns *nodeState) { ns.draining = true })
+	n.logger.Info("draining")
+}
+
+func (n *Node) getHealthz(request *restful.Request, response *restful.Response) {
+	response.WriteEntity(map[string]string{"status": "ok"})
+}
+
+func (n *Node) getReadyz(request *restful.Request, response *restful.Response) {
+	readiness := n.Readiness()
+	if !readiness.Ready {
+		response.WriteHeaderAndEntity(http.StatusServiceUnavailable, readiness)
+		return
+	}
+	response.WriteEntity(readiness)
+}
+
+func (n *Node) handoff(ctx context.Context) error {
+	var failed error
+	for id, address := range n.registry.GetAll() {
+		if id == n.ID || n.protocolFor(id, true) == legacyProtocol {
+			continue
+		}
+		count, err := n.handoffRemote(ctx, address)
+		if err != nil {
+			n.logger.Warn("unable to receive handoff", F(peerField, address), F(errorField, err))
+			failed = err
+			continue
+		}
+		n.logger.Info("received handoff", F(peerField, address), F("keys", count))
+	}
+	return failed
+}
+
+func (n *Node) handoffRemote(ctx context.Context, address string) (int, error) {
+	uri := address + handoffPath + "?" + url.Values{idParam: []string{strconv.Itoa(n.ID)}}.Encode()
+	n.logger.Debug("sending handoff request", F(peerField, uri))
+	ctx, cancel := n.bulkContext(ctx)
+	defer cancel()
+	response, err := n.open(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code '%d'", response.StatusCode)
+	}
+
+	count := 0
+	decoder := json.NewDecoder(response.Body)
+	for {
+		item := &handoffItem{}
+		err = decoder.Decode(item)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if n.applyEntry(item.Key, &Entry{Value: item.Value, Version: item.Version, Expiry: item.Expiry, ContentType: item.ContentType, Meta: item.Meta}) {
+			count++
+		}
+	}
+}
+
+func (n *Node) getHandoff(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.QueryParameter(idParam))
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, errors.New("id of the receiving node is required"))
+		return
+	}
+
+	response.AddHeader("Content-Type", ndjsonMime)
+	response.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(response)
+	now := time.Now()
+	for _, key := range n.store.GetKeys(0, n.store.Size()) {
+		entry := n.store.GetEntry(key)
+		if entry == nil || entry.Expired(now) {
+			continue
+		}
+		if !containsID(n.bestMatches(key, n.replicasFor(key)+1, []int{}), id) {
+			continue
+		}
+		entry, err := decodeEntry(entry)
+		if err != nil {
+			n.logger.Warn("unable to decompress value", F(keyField, key), F(errorField, err))
+			continue
+		}
+		err = encoder.Encode(&handoffItem{Key: key, Value: entry.Value, ContentType: entry.ContentType, Meta: entry.Meta, Version: entry.Version, Expiry: entry.Expiry})
+		if err != nil {
+			n.logger.Debug("unable to send handoff", F(errorField, err))
+			return
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package corduroy
+
+import (
+	"github.com/emicklei/go-restful"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+const syncOK = "ok"
+const syncError = "error"
+const syncRemoved = "removed"
+
+type nodeMetrics struct {
+	requests           *counterVec
+	latency            *histogramVec
+	hops               *histogramVec
+	peerRequests       *counterVec
+	peerErrors         *counterVec
+	peerRetries        *counterVec
+	wireRequests       *counterVec
+	cacheRequests      *counterVec
+	cacheInvalidations *counterVec
+	coalesced          *counterVec
+	compression        *counterVec
+	syncs              *counterVec
+	replicationLag     *histogramVec
+}
+
+func newNodeMetrics() *nodeMetrics {
+	return &nodeMetrics{
+		requests:           newCounterVec("corduroy_http_requests_total", "Requests handled by route, method and status code.", "route", "method", "code"),
+		latency:            newHistogramVec("corduroy_http_request_duration_seconds", "Time taken to handle requests by route and method.", latencyBuckets, "route", "method"),
+		hops:               newHistogramVec("corduroy_forwarded_request_hops", "Nodes a forwarded request visited before reaching this node.", hopBuckets),
+		peerRequests:       newCounterVec("corduroy_peer_requests_total", "Requests sent to each peer node.", "peer"),
+		peerErrors:         newCounterVec("corduroy_peer_errors_total", "Requests to each peer node that failed or returned a server error.", "peer"),
+		peerRetries:        newCounterVec("corduroy_peer_retries_total", "Idempotent requests to each peer node that were retried.", "peer"),
+		wireRequests:       newCounterVec("corduroy_wire_requests_total", "Peer requests served over the binary wire protocol."),
+		cacheRequests:      newCounterVec("corduroy_cache_requests_total", "Forwarded reads answered from the hot-key cache or missing it.", "result"),
+		cacheInvalidations: newCounterVec("corduroy_cache_invalidations_total", "Cached values dropped because their owner changed them."),
+		coalesced:          newCounterVec("corduroy_coalesced_requests_total", "Reads that shared an identical in-flight peer request or store read.", "source"),
+		compression:        newCounterVec("corduroy_compression_bytes_total", "Bytes of values offered for compression and bytes stored after it.", "stage"),
+		syncs:              newCounterVec("corduroy_sync_total", "Background sync attempts by loop and result.", "loop", "result"),
+		replicationLag:     newHistogramVec("corduroy_replication_lag_seconds", "Time between a write being versioned by its owner and applied on a replica.", latencyBuckets),
+	}
+}
+
+func (n *Node) Metrics() []*MetricFamily {
+	collectors := []metricCollector{
+		n.metrics.requests,
+		n.metrics.latency,
+		n.metrics.hops,
+		n.metrics.peerRequests,
+		n.metrics.peerErrors,
+		n.metrics.peerRetries,
+		n.metrics.wireRequests,
+		newGaugeFunc("corduroy_wire_connections", "Open wire protocol connections to and from peer nodes.", func() float64 {
+			return float64(n.wire.connections())
+		}),
+		n.metrics.cacheRequests,
+		n.metrics.cacheInvalidations,
+		n.metrics.coalesced,
+		n.metrics.compression,
+		newGaugeFunc("corduroy_compression_ratio", "Stored size of values offered for compression as a fraction of their original size.", n.compressionRatio),
+		newGaugeFunc("corduroy_cache_entries", "Values held in the hot-key cache.", func() float64 {
+			return float64(n.cache.size())
+		}),
+		newGaugeFunc("corduroy_cache_hit_ratio", "Fraction of forwarded reads answered from the hot-key cache.", func() float64 {
+			hits := n.metrics.cacheRequests.get(cacheHit)
+			total := hits + n.metrics.cacheRequests.get(cacheMiss)
+			if total == 0 {
+				return 0
+			}
+			return hits / total
+		}),
+		n.metrics.syncs,
+		n.metrics.replicationLag,
+		newGaugeFunc("corduroy_registry_nodes", "Nodes known to this node's registry.", func() float64 {
+			return float64(n.registry.Size())
+		}),
+		newGaugeFunc("corduroy_peer_circuits_open", "Peer nodes skipped because their circuit breaker is open.", func() float64 {
+			open := 0
+			for id := range n.registry.GetAll() {
+				if !n.available(id) {
+					open++
+				}
+			}
+			return float64(open)
+		}),
+		newGaugeFunc("corduroy_store_keys", "Keys held in this node's store.", func() float64 {
+			return float64(n.store.Size())
+		}),
+		newGaugeFunc("corduroy_store_bytes", "Bytes of keys and values held in this node's store.", func() float64 {
+			return float64(n.storeBytes())
+		}),
+	}
+
+	families := make([]*MetricFamily, 0, len(collectors))
+	for _, c := range collectors {
+		families = append(families, c.collect())
+	}
+	return families
+}
+
+func (n *Node) WriteMetrics(w io.Writer) error {
+	return writeMetrics(w, n.Metrics())
+}
+
+func (n *Node) storeBytes() int {
+	total := 0
+	for _, key := range n.store.GetKeys(0, n.store.Size()) {
+		entry := n.store.GetEntry(key)
+		if entry != nil {
+			total += len(key) + len(entry.Value)
+		}
+	}
+	return total
+}
+
+func (n *Node) instrument(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	start := time.Now()
+	if visited := request.HeaderParameter(visitedHeader); visited != "" {
+		n.metrics.hops.observe(float64(len(strings.Split(visited, ","))))
+	}
+	chain.ProcessFilter(request, response)
+
+	route := strings.TrimPrefix(request.SelectedRoutePath(), strings.TrimSuffix(n.service.RootPath(), "/"))
+	method := request.Request.Method
+	n.metrics.requests.add(1, route, method, strconv.Itoa(response.StatusCode()))
+	n.metrics.latency.observe(time.Since(start).Seconds(), route, method)
+}
+
+func (n *Node) observePeer(uri string, statusCode int, err error) {
+	id, known := n.peerID(uri)
+	peer := peerLabel(id, known)
+	n.metrics.peerRequests.add(1, peer)
+	failed := err != nil || statusCode >= http.StatusInternalServerError
+	if failed {
+		n.metrics.peerErrors.add(1, peer)
+	}
+	if known && id != n.ID {
+		n.peers.record(id, !failed)
+	}
+}
+
+func peerLabel(id int, known bool) string {
+	if !known {
+		return "unknown"
+	}
+	return strconv.Itoa(id)
+}
+
+func (n *Node) observeReplication(version uint64) {
+	lag := time.Since(time.Unix(0, int64(version)))
+	if lag >= 0 {
+		n.metrics.replicationLag.observe(lag.Seconds())
+	}
+}
+
+func (n *Node) getMetrics(request *restful.Request, response *restful.Response) {
+	response.AddHeader("Content-Type", metricsMime)
+	response.WriteHeader(http.StatusOK)
+	err := n.WriteMetrics(response)
+	if err != nil {
+		n.logger.Debug("unable to write metrics", F(errorField, err))
+	}
+}
@@ -0,0 +1,326 @@
+package corduroy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type namespaceStatus struct {
+	*Namespace
+	Usage NamespaceUsage `json:"usage"`
+}
+
+func entityPath(key string) string {
+	ns, k := splitNamespaceKey(key)
+	if ns == defaultNamespace {
+		return entitiesPath + "/" + url.QueryEscape(k)
+	}
+	return namespacesPath + "/" + url.QueryEscape(ns) + entitiesPath + "/" + url.QueryEscape(k)
+}
+
+func authorizationKey(request *restful.Request) (string, error) {
+	ns := request.PathParameter(namespaceParam)
+	if request.PathParameter(keyPath) == "" {
+		return namespacesPath + "/" + ns, nil
+	}
+
+	key, err := url.QueryUnescape(request.PathParameter(keyPath))
+	if err != nil {
+		return "", err
+	}
+	return displayKey(ns, key), nil
+}
+
+func entityKey(request *restful.Request, response *restful.Response, ns string) (string, bool) {
+	key, err := url.QueryUnescape(request.PathParameter(keyPath))
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return "", false
+	}
+	err = validateKey(key)
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return namespaceKey(ns, key), true
+}
+
+func displayKey(ns string, key string) string {
+	if ns == defaultNamespace {
+		return key
+	}
+	return ns + "/" + key
+}
+
+func (n *Node) CreateNamespace(ns *Namespace) error {
+	err := validateNamespace(ns)
+	if err != nil {
+		return err
+	}
+
+	created := *ns
+	if created.Replicas == 0 {
+		created.Replicas = redundantCopies
+	}
+	created.Revision = time.Now().UnixNano()
+	created.Dropped = false
+	n.namespaces.merge(&created)
+	n.logger.Info("created namespace", F("namespace", created.Name))
+	n.broadcastNamespace(&created)
+	return nil
+}
+
+func (n *Node) DropNamespace(name string) error {
+	ns, found := n.namespaces.get(name)
+	if !found {
+		return errors.New("namespace '" + name + "' not found")
+	}
+
+	ns.Revision = time.Now().UnixNano()
+	ns.Dropped = true
+	n.namespaces.merge(ns)
+	n.purgeNamespace(name)
+	n.logger.Info("dropped namespace", F("namespace", name))
+	n.broadcastNamespace(ns)
+	return nil
+}
+
+func (n *Node) Namespaces() []*Namespace {
+	return n.namespaces.live()
+}
+
+func (n *Node) NamespaceUsage(name string) NamespaceUsage {
+	local := n.localNamespaceUsage()[name]
+	peers := n.namespaces.peerTotal(name)
+	return NamespaceUsage{
+		Keys:  local.Keys + peers.Keys,
+		Bytes: local.Bytes + peers.Bytes,
+	}
+}
+
+func (n *Node) replicasFor(key string) int {
+	name, _ := splitNamespaceKey(key)
+	if ns, found := n.namespaces.get(name); found {
+		return ns.Replicas
+	}
+	return redundantCopies
+}
+
+func (n *Node) checkNamespaceLimits(ns *Namespace, key string, value []byte) (int, error) {
+	if ns.MaxValueBytes > 0 && len(value) > ns.MaxValueBytes {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("value exceeds the '%d' byte limit of namespace '%s'", ns.MaxValueBytes, ns.Name)
+	}
+	if ns.MaxKeys == 0 && ns.MaxBytes == 0 {
+		return http.StatusOK, nil
+	}
+
+	usage := n.NamespaceUsage(ns.Name)
+	added := int64(len(value))
+	if entry, found := n.lookup(key); found {
+		added -= int64(len(entry.Value))
+	} else {
+		usage.Keys++
+	}
+	if ns.MaxKeys > 0 && usage.Keys > ns.MaxKeys {
+		return http.StatusInsufficientStorage, fmt.Errorf("namespace '%s' is limited to '%d' keys", ns.Name, ns.MaxKeys)
+	}
+	if ns.MaxBytes > 0 && usage.Bytes+added > ns.MaxBytes {
+		return http.StatusInsufficientStorage, fmt.Errorf("namespace '%s' is limited to '%d' bytes", ns.Name, ns.MaxBytes)
+	}
+	return http.StatusOK, nil
+}
+
+func (n *Node) localNamespaceUsage() map[string]NamespaceUsage {
+	usage := make(map[string]NamespaceUsage)
+	now := time.Now()
+	for _, key := range n.store.GetKeys(0, n.store.Size()) {
+		name, _ := splitNamespaceKey(key)
+		if name == defaultNamespace || n.bestMatch(key, []int{}) != n.ID {
+			continue
+		}
+		entry := n.store.GetEntry(key)
+		if entry == nil || entry.Expired(now) {
+			continue
+		}
+		u := usage[name]
+		u.Keys++
+		u.Bytes += int64(len(entry.Value))
+		usage[name] = u
+	}
+	return usage
+}
+
+func (n *Node) purgeNamespace(name string) {
+	prefix := namespaceKey(name, "")
+	for _, key := range n.store.GetKeys(0, n.store.Size()) {
+		if strings.HasPrefix(key, prefix) {
+			n.deleteEntry(key, SystemOrigin)
+		}
+	}
+}
+
+func (n *Node) lookupNamespace(request *restful.Request, response *restful.Response) (*Namespace, bool) {
+	name := request.PathParameter(namespaceParam)
+	if name == chunkNamespace && n.isPeer(request) {
+		return &Namespace{Name: chunkNamespace, Replicas: redundantCopies}, true
+	}
+	ns, found := n.namespaces.get(name)
+	if !found {
+		response.WriteErrorString(http.StatusNotFound, "namespace '"+name+"' not found")
+		return nil, false
+	}
+	return ns, true
+}
+
+func (n *Node) getNamespacedValue(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	key, ok := entityKey(request, response, ns.Name)
+	if !ok {
+		return
+	}
+	n.serveValue(request, response, key)
+}
+
+func (n *Node) putNamespacedValue(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	key, ok := entityKey(request, response, ns.Name)
+	if !ok {
+		return
+	}
+	n.storeValue(request, response, key, ns)
+}
+
+func (n *Node) deleteNamespacedValue(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	key, ok := entityKey(request, response, ns.Name)
+	if !ok {
+		return
+	}
+	n.removeValue(request, response, key)
+}
+
+func (n *Node) getNamespaces(request *restful.Request, response *restful.Response) {
+	snapshot := &namespaceSnapshot{
+		Namespaces: n.namespaces.all(),
+		Usage:      n.localNamespaceUsage(),
+	}
+	response.WriteEntity(snapshot)
+	n.logger.Debug("provided namespaces", F("namespaces", len(snapshot.Namespaces)))
+}
+
+func (n *Node) getNamespace(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	response.WriteEntity(&namespaceStatus{
+		Namespace: ns,
+		Usage:     n.NamespaceUsage(ns.Name),
+	})
+}
+
+func (n *Node) putNamespace(request *restful.Request, response *restful.Response) {
+	ns := &Namespace{}
+	err := request.ReadEntity(ns)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	ns.Name = request.PathParameter(namespaceParam)
+
+	if n.isPeer(request) {
+		if n.namespaces.merge(ns) && ns.Dropped {
+			n.purgeNamespace(ns.Name)
+		}
+		n.logger.Info("received namespace", F("namespace", ns.Name), F("revision", ns.Revision))
+		response.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = n.CreateNamespace(ns)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	created, _ := n.namespaces.get(ns.Name)
+	response.WriteEntity(created)
+}
+
+func (n *Node) deleteNamespace(request *restful.Request, response *restful.Response) {
+	err := n.DropNamespace(request.PathParameter(namespaceParam))
+	if err != nil {
+		response.WriteError(http.StatusNotFound, err)
+		return
+	}
+	response.WriteHeader(http.StatusOK)
+}
+
+func (n *Node) broadcastNamespace(ns *Namespace) {
+	b, err := json.Marshal(ns)
+	if err != nil {
+		n.logger.Error("unable to encode namespace", F("namespace", ns.Name), F(errorField, err))
+		return
+	}
+
+	for id, address := range n.registry.GetAll() {
+		if id == n.ID {
+			continue
+		}
+		uri := address + namespacesPath + "/" + url.QueryEscape(ns.Name)
+		n.logger.Debug("sending namespace request", F(peerField, uri))
+		statusCode, _, err := n.send(context.Background(), "PUT", uri, string(b), buildPeerHeader([]int{n.ID}, 0))
+		if err == nil && statusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status code '%d'", statusCode)
+		}
+		if err != nil {
+			n.logger.Warn("unable to send namespace", F("namespace", ns.Name), F(peerField, address), F(errorField, err))
+		}
+	}
+}
+
+func (n *Node) syncNamespacesRemote(id int, address string) error {
+	uri := address + namespacesPath
+	if n.peerProtocol(uri) == legacyProtocol {
+		return nil
+	}
+	n.logger.Debug("sending sync namespaces request", F(peerField, uri))
+	statusCode, body, err := n.send(context.Background(), "GET", uri, "", buildPeerHeader([]int{n.ID}, 0))
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code '%d' syncing namespaces from '%s'", statusCode, address)
+	}
+
+	snapshot := &namespaceSnapshot{}
+	err = json.Unmarshal([]byte(body), snapshot)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range snapshot.Namespaces {
+		if n.namespaces.merge(ns) && ns.Dropped {
+			n.purgeNamespace(ns.Name)
+		}
+	}
+	if id >= 0 {
+		n.namespaces.setPeerUsage(id, snapshot.Usage)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package corduroy
+
+import (
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const protocolHeader = "X-Corduroy-Protocol"
+const protocolsHeader = "X-Corduroy-Protocols"
+const protocolAttribute = "protocol"
+
+const legacyProtocol = 1
+
+var supportedProtocols = []int{1, 2, 3}
+
+type nodeInfo struct {
+	ID       int    `json:"id"`
+	Address  string `json:"address"`
+	Protocol int    `json:"protocol"`
+}
+
+func (n *Node) UseMinProtocol(version int) error {
+	protocols := make([]int, 0, len(supportedProtocols))
+	for _, v := range supportedProtocols {
+		if v >= version {
+			protocols = append(protocols, v)
+		}
+	}
+	if len(protocols) == 0 {
+		return fmt.Errorf("protocol version '%d' is newer than this node supports, %s", version, formatVersions(supportedProtocols))
+	}
+	n.protocols = protocols
+	return nil
+}
+
+func (n *Node) maxProtocol() int {
+	return n.protocols[len(n.protocols)-1]
+}
+
+func (n *Node) protocolFor(id int, known bool) int {
+	if known {
+		if version := n.peers.protocol(id); version > 0 {
+			return version
+		}
+	}
+	return n.maxProtocol()
+}
+
+func (n *Node) peerProtocol(uri string) int {
+	id, known := n.peerID(uri)
+	return n.protocolFor(id, known)
+}
+
+func (n *Node) negotiate(offered []int) int {
+	best := 0
+	for _, v := range offered {
+		if v > best && containsID(n.protocols, v) {
+			best = v
+		}
+	}
+	return best
+}
+
+func setProtocol(header http.Header, version int) {
+	if version > legacyProtocol {
+		header.Set(protocolHeader, strconv.Itoa(version))
+	} else {
+		header.Del(protocolHeader)
+	}
+}
+
+func responseProtocol(header http.Header) int {
+	version, err := strconv.Atoi(header.Get(protocolHeader))
+	if err != nil || version < legacyProtocol {
+		return legacyProtocol
+	}
+	return version
+}
+
+func (n *Node) observeProtocol(id int, statusCode int, header http.Header) {
+	if header == nil || statusCode == http.StatusUpgradeRequired {
+		return
+	}
+	if version := n.negotiate([]int{responseProtocol(header)}); version > 0 {
+		n.peers.setProtocol(id, version)
+	}
+}
+
+func (n *Node) negotiateProtocol(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	v := request.HeaderParameter(protocolHeader)
+	if v == "" && request.HeaderParameter(visitedHeader) == "" {
+		chain.ProcessFilter(request, response)
+		return
+	}
+
+	version := legacyProtocol
+	if v != "" {
+		var err error
+		version, err = strconv.Atoi(v)
+		if err != nil {
+			response.WriteErrorString(http.StatusBadRequest, "protocol version should be an integer")
+			return
+		}
+	}
+	if version < n.protocols[0] {
+		n.logger.Warn("refused peer request with an unsupported protocol", F("protocol", version), F("remote", request.Request.RemoteAddr))
+		response.AddHeader(protocolsHeader, formatVersions(n.protocols))
+		response.WriteErrorString(http.StatusUpgradeRequired, fmt.Sprintf("protocol version '%d' is not supported, this node speaks %s", version, formatVersions(n.protocols)))
+		return
+	}
+	if version > n.maxProtocol() {
+		version = n.maxProtocol()
+	}
+	request.SetAttribute(protocolAttribute, version)
+	setProtocol(response.Header(), version)
+	chain.ProcessFilter(request, response)
+}
+
+func requestProtocol(request *restful.Request) int {
+	if version, ok := request.Attribute(protocolAttribute).(int); ok {
+		return version
+	}
+	return legacyProtocol
+}
+
+func (n *Node) registryInfo() []nodeInfo {
+	nodes := make([]nodeInfo, 0)
+	for id, address := range n.registry.GetAll() {
+		version := n.maxProtocol()
+		if id != n.ID {
+			version = n.peers.protocol(id)
+		}
+		nodes = append(nodes, nodeInfo{ID: id, Address: address, Protocol: version})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+func parseProtocols(s string) []int {
+	if strings.TrimSpace(s) == "" {
+		return []int{legacyProtocol}
+	}
+	versions := make([]int, 0)
+	for _, v := range strings.Split(s, ",") {
+		if version, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+func formatVersions(versions []int) string {
+	s := make([]string, len(versions))
+	for i, v := range versions {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,293 @@
+package corduroy
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const rangePath = "/range"
+const startParam = "start"
+const endParam = "end"
+const ndjsonMime = "application/x-ndjson"
+
+var errRangeLimit = errors.New("range limit reached")
+
+type RangeItem struct {
+	Key         string `json:"key"`
+	Value       []byte `json:"-"`
+	Size        int64  `json:"size,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+}
+
+type rangeSource interface {
+	next() (*RangeItem, error)
+	close()
+}
+
+type localRangeSource struct {
+	node  *Node
+	keys  []string
+	owned bool
+	now   time.Time
+}
+
+func (lrs *localRangeSource) next() (*RangeItem, error) {
+	for len(lrs.keys) > 0 {
+		key := lrs.keys[0]
+		lrs.keys = lrs.keys[1:]
+		if lrs.owned && lrs.node.bestMatch(key, []int{}) != lrs.node.ID {
+			continue
+		}
+		entry := lrs.node.store.GetEntry(key)
+		if entry == nil || entry.Expired(lrs.now) {
+			continue
+		}
+		entry, err := decodeEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		item := &RangeItem{Key: key, ContentType: entry.ContentType, ETag: formatETag(entry.Version)}
+		item.Value, item.Size = listedValue(entry.Value)
+		return item, nil
+	}
+	return nil, io.EOF
+}
+
+func (lrs *localRangeSource) close() {
+}
+
+type remoteRangeSource struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+}
+
+func (rrs *remoteRangeSource) next() (*RangeItem, error) {
+	item := &RangeItem{}
+	err := rrs.decoder.Decode(item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (rrs *remoteRangeSource) close() {
+	rrs.body.Close()
+}
+
+type rangeHead struct {
+	item   *RangeItem
+	source rangeSource
+}
+
+type rangeHeap []*rangeHead
+
+func (rh rangeHeap) Len() int            { return len(rh) }
+func (rh rangeHeap) Less(i, j int) bool  { return rh[i].item.Key < rh[j].item.Key }
+func (rh rangeHeap) Swap(i, j int)       { rh[i], rh[j] = rh[j], rh[i] }
+func (rh *rangeHeap) Push(x interface{}) { *rh = append(*rh, x.(*rangeHead)) }
+func (rh *rangeHeap) Pop() interface{} {
+	old := *rh
+	head := old[len(old)-1]
+	*rh = old[:len(old)-1]
+	return head
+}
+
+func (n *Node) localRange(start string, end string, owned bool) rangeSource {
+	keys := n.sortedKeys(start, end)
+	if !strings.Contains(start, namespaceSeparator) {
+		filtered := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if !strings.Contains(key, namespaceSeparator) {
+				filtered = append(filtered, key)
+			}
+		}
+		keys = filtered
+	}
+	return &localRangeSource{node: n, keys: keys, owned: owned, now: time.Now()}
+}
+
+func (n *Node) remoteRange(ctx context.Context, address string, start string, end string) (rangeSource, error) {
+	query := url.Values{}
+	query.Set(startParam, start)
+	query.Set(endParam, end)
+	uri := address + rangePath + "?" + query.Encode()
+	n.logger.Debug("sending range request", F(peerField, uri))
+	response, err := n.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("unexpected status code '%d' from '%s'", response.StatusCode, address)
+	}
+	return &remoteRangeSource{body: response.Body, decoder: json.NewDecoder(response.Body)}, nil
+}
+
+func (n *Node) clusterRange(ctx context.Context, start string, end string, emit func(*RangeItem) error) error {
+	sources := make([]rangeSource, 0)
+	defer func() {
+		for _, source := range sources {
+			source.close()
+		}
+	}()
+
+	for id, address := range n.registry.GetAll() {
+		if id == n.ID {
+			sources = append(sources, n.localRange(start, end, true))
+			continue
+		}
+		source, err := n.remoteRange(ctx, address, start, end)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	heads := &rangeHeap{}
+	for _, source := range sources {
+		item, err := source.next()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		heap.Push(heads, &rangeHead{item: item, source: source})
+	}
+
+	first := true
+	last := ""
+	for heads.Len() > 0 {
+		head := heap.Pop(heads).(*rangeHead)
+		if first || head.item.Key != last {
+			err := emit(head.item)
+			if err == errRangeLimit {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			last = head.item.Key
+			first = false
+		}
+
+		item, err := head.source.next()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		head.item = item
+		heap.Push(heads, head)
+	}
+	return nil
+}
+
+func (n *Node) Range(start string, end string, limit int) ([]*RangeItem, error) {
+	items := make([]*RangeItem, 0)
+	err := n.clusterRange(context.Background(), start, end, func(item *RangeItem) error {
+		items = append(items, item)
+		if limit > 0 && len(items) >= limit {
+			return errRangeLimit
+		}
+		return nil
+	})
+	return items, err
+}
+
+func (n *Node) rangeValues(request *restful.Request, response *restful.Response) {
+	n.serveRange(request, response, defaultNamespace)
+}
+
+func (n *Node) rangeNamespacedValues(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	n.serveRange(request, response, ns.Name)
+}
+
+func (n *Node) serveRange(request *restful.Request, response *restful.Response, ns string) {
+	limit := 0
+	if l := request.QueryParameter(limitParam); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil {
+			response.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		limit = parsed
+	}
+
+	start := request.QueryParameter(startParam)
+	end := request.QueryParameter(endParam)
+	peer := n.isPeer(request)
+	if !peer {
+		for _, key := range []string{start, end} {
+			if err := validateKey(key); err != nil {
+				response.WriteErrorString(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		start = namespaceKey(ns, start)
+		if end != "" {
+			end = namespaceKey(ns, end)
+		} else if ns != defaultNamespace {
+			end = prefixEnd(namespaceKey(ns, ""))
+		}
+	}
+
+	response.AddHeader("Content-Type", ndjsonMime)
+	response.WriteHeader(http.StatusOK)
+	flusher, _ := response.ResponseWriter.(http.Flusher)
+	encoder := json.NewEncoder(response)
+	count := 0
+	emit := func(item *RangeItem) error {
+		if !peer {
+			_, k := splitNamespaceKey(item.Key)
+			if !n.allowed(request, displayKey(ns, k), false) {
+				return nil
+			}
+			item = &RangeItem{Key: k, Value: item.Value, Size: item.Size, ContentType: item.ContentType, ETag: item.ETag}
+		}
+		err := encoder.Encode(item)
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		count++
+		if limit > 0 && count >= limit {
+			return errRangeLimit
+		}
+		return nil
+	}
+
+	var err error
+	if peer {
+		source := n.localRange(start, end, true)
+		for item, e := source.next(); e == nil; item, e = source.next() {
+			if err = emit(item); err != nil {
+				break
+			}
+		}
+	} else {
+		err = n.clusterRange(request.Request.Context(), start, end, emit)
+	}
+	if err != nil && err != errRangeLimit {
+		n.logger.Warn("unable to complete range request", F(errorField, err))
+		encoder.Encode(map[string]string{"error": err.Error()})
+	}
+}
@@ -0,0 +1,279 @@
+package corduroy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prefixParam = "prefix"
+const cursorParam = "cursor"
+const limitParam = "limit"
+const scopeParam = "scope"
+const ownedParam = "owned"
+const clusterScope = "cluster"
+
+const defaultScanLimit = 100
+const maxScanLimit = 1000
+
+type ScanResult struct {
+	Keys   []string `json:"keys"`
+	Cursor string   `json:"cursor,omitempty"`
+}
+
+func encodeCursor(key string) string {
+	if key == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor '%s'", cursor)
+	}
+	return string(b), nil
+}
+
+func (n *Node) ScanKeys(prefix string, cursor string, limit int, cluster bool) (*ScanResult, error) {
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxScanLimit {
+		limit = defaultScanLimit
+	}
+
+	var keys []string
+	if cluster {
+		keys, err = n.scanCluster(context.Background(), prefix, after, limit)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		keys = n.scanLocal(prefix, after, limit, false)
+	}
+	return buildScanResult(keys, limit), nil
+}
+
+func buildScanResult(keys []string, limit int) *ScanResult {
+	result := &ScanResult{Keys: keys}
+	if len(keys) >= limit {
+		result.Cursor = encodeCursor(keys[len(keys)-1])
+	}
+	return result
+}
+
+func (n *Node) sortedKeys(start string, end string) []string {
+	if rs, ok := n.store.(RangeStore); ok {
+		return rs.Range(start, end, 0)
+	}
+
+	keys := n.store.GetKeys(0, n.store.Size())
+	sort.Strings(keys)
+	first := sort.SearchStrings(keys, start)
+	last := len(keys)
+	if end != "" {
+		last = sort.SearchStrings(keys, end)
+	}
+	if first > last {
+		first = last
+	}
+	return keys[first:last]
+}
+
+func (n *Node) scanLocal(prefix string, after string, limit int, owned bool) []string {
+	start := prefix
+	if after != "" && after >= start {
+		start = after + "\x00"
+	}
+	keys := n.sortedKeys(start, prefixEnd(prefix))
+
+	now := time.Now()
+	namespaced := strings.Contains(prefix, namespaceSeparator)
+	matches := make([]string, 0, limit)
+	for _, key := range keys {
+		if !namespaced && strings.Contains(key, namespaceSeparator) {
+			continue
+		}
+		entry := n.store.GetEntry(key)
+		if entry == nil || entry.Expired(now) {
+			continue
+		}
+		if owned && n.bestMatch(key, []int{}) != n.ID {
+			continue
+		}
+		matches = append(matches, key)
+		if len(matches) >= limit {
+			break
+		}
+	}
+	return matches
+}
+
+func (n *Node) scanCluster(ctx context.Context, prefix string, after string, limit int) ([]string, error) {
+	nodes := n.registry.GetAll()
+	pages := make([][]string, 0, len(nodes))
+	errs := make([]error, 0)
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for id, address := range nodes {
+		wg.Add(1)
+		go func(id int, address string) {
+			defer wg.Done()
+			var keys []string
+			var err error
+			if id == n.ID {
+				keys = n.scanLocal(prefix, after, limit, true)
+			} else {
+				keys, err = n.scanRemote(ctx, address, prefix, after, limit)
+			}
+
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("node '%d': %s", id, err))
+				return
+			}
+			pages = append(pages, keys)
+		}(id, address)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return mergeSortedKeys(pages, limit), nil
+}
+
+func mergeSortedKeys(pages [][]string, limit int) []string {
+	merged := make([]string, 0, limit)
+	for _, page := range pages {
+		merged = append(merged, page...)
+	}
+	sort.Strings(merged)
+
+	keys := make([]string, 0, limit)
+	for i, key := range merged {
+		if i > 0 && key == merged[i-1] {
+			continue
+		}
+		keys = append(keys, key)
+		if len(keys) >= limit {
+			break
+		}
+	}
+	return keys
+}
+
+func (n *Node) scanRemote(ctx context.Context, address string, prefix string, after string, limit int) ([]string, error) {
+	query := url.Values{}
+	query.Set(prefixParam, prefix)
+	query.Set(cursorParam, encodeCursor(after))
+	query.Set(limitParam, strconv.Itoa(limit))
+	query.Set(ownedParam, "true")
+	uri := address + entitiesPath + "?" + query.Encode()
+	n.logger.Debug("sending scan request", F(peerField, uri))
+	ctx, cancel := n.bulkContext(ctx)
+	defer cancel()
+	statusCode, body, err := n.send(ctx, "GET", uri, "", buildPeerHeader([]int{n.ID}, 0))
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code '%d'", statusCode)
+	}
+
+	result := &ScanResult{}
+	err = json.Unmarshal([]byte(body), result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Keys, nil
+}
+
+func (n *Node) listValues(request *restful.Request, response *restful.Response) {
+	n.serveScan(request, response, defaultNamespace)
+}
+
+func (n *Node) listNamespacedValues(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	n.serveScan(request, response, ns.Name)
+}
+
+func (n *Node) serveScan(request *restful.Request, response *restful.Response, ns string) {
+	limit := defaultScanLimit
+	if l := request.QueryParameter(limitParam); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil {
+			response.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		limit = parsed
+	}
+	if limit <= 0 || limit > maxScanLimit {
+		limit = defaultScanLimit
+	}
+	after, err := decodeCursor(request.QueryParameter(cursorParam))
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	if n.isPeer(request) {
+		owned := request.QueryParameter(ownedParam) == "true"
+		keys := n.scanLocal(request.QueryParameter(prefixParam), after, limit, owned)
+		response.WriteEntity(&ScanResult{Keys: keys})
+		return
+	}
+
+	for _, key := range []string{request.QueryParameter(prefixParam), after} {
+		if err := validateKey(key); err != nil {
+			response.WriteErrorString(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	prefix := namespaceKey(ns, request.QueryParameter(prefixParam))
+	if after != "" {
+		after = namespaceKey(ns, after)
+	}
+	var keys []string
+	if request.QueryParameter(scopeParam) == clusterScope {
+		keys, err = n.scanCluster(request.Request.Context(), prefix, after, limit)
+		if err != nil {
+			response.WriteError(http.StatusBadGateway, err)
+			return
+		}
+	} else {
+		keys = n.scanLocal(prefix, after, limit, false)
+	}
+
+	result := &ScanResult{Keys: make([]string, 0, len(keys))}
+	for _, key := range keys {
+		_, k := splitNamespaceKey(key)
+		if n.allowed(request, displayKey(ns, k), false) {
+			result.Keys = append(result.Keys, k)
+		}
+	}
+	if len(keys) >= limit {
+		_, last := splitNamespaceKey(keys[len(keys)-1])
+		result.Cursor = encodeCursor(last)
+	}
+	response.WriteEntity(result)
+}
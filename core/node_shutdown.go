@@ -0,0 +1,183 @@
+package corduroy
+
+import (
+	"context"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stopTimeoutSeconds = 5
+const leaveBatchSize = 500
+
+type Flusher interface {
+	Flush() error
+}
+
+func (n *Node) UseSeeds(seeds ...string) {
+	n.seedsMux.Lock()
+	defer n.seedsMux.Unlock()
+	n.seeds = seeds
+}
+
+func (n *Node) Shutdown(ctx context.Context) error {
+	n.Drain()
+	failed := n.leave(ctx)
+
+	n.logger.Info("stopping server")
+	for _, ticker := range n.tickers {
+		ticker.Stop()
+	}
+	select {
+	case <-n.done:
+	default:
+		close(n.done)
+	}
+
+	err := n.server.Shutdown(ctx)
+	if err != nil {
+		n.logger.Warn("in-flight requests did not finish before the deadline", F(errorField, err))
+		n.server.Close()
+		failed = err
+	}
+	n.wire.close(ctx)
+	n.registry.Delete(n.ID)
+
+	err = n.flush()
+	if err != nil {
+		n.logger.Error("unable to flush", F(errorField, err))
+		failed = err
+	}
+	n.logger.Info("stopped")
+	return failed
+}
+
+func (n *Node) leave(ctx context.Context) error {
+	batches := make(map[int][]batchItem)
+	var failed error
+	send := func(id int) {
+		items := batches[id]
+		delete(batches, id)
+		keys := make([]string, len(items))
+		for i, item := range items {
+			keys[i] = item.Key
+		}
+		address := n.registry.Get(id)
+		for _, result := range n.batchRemote(ctx, address, batchPutPath, &batchRequest{Entries: items}, keys, []int{n.ID}) {
+			if result.Status != http.StatusOK {
+				failed = fmt.Errorf("unable to hand off '%s' to '%s', %s", result.Key, address, result.Error)
+			}
+		}
+	}
+
+	now := time.Now()
+	moved := 0
+	for _, key := range n.store.GetKeys(0, n.store.Size()) {
+		if ctx.Err() != nil {
+			break
+		}
+		entry := n.store.GetEntry(key)
+		if entry == nil || entry.Expired(now) {
+			continue
+		}
+		entry, err := decodeEntry(entry)
+		if err != nil {
+			n.logger.Warn("unable to decompress value", F(keyField, key), F(errorField, err))
+			continue
+		}
+		item := batchItem{Key: key, Value: entry.Value, ContentType: entry.ContentType, Meta: entry.Meta, Version: entry.Version}
+		if !entry.Expiry.IsZero() {
+			item.Expires = formatExpiry(entry.Expiry)
+		}
+		for _, id := range n.bestMatches(key, n.replicasFor(key)+1, []int{n.ID}) {
+			batches[id] = append(batches[id], item)
+			if len(batches[id]) >= leaveBatchSize {
+				send(id)
+			}
+		}
+		moved++
+	}
+	for id := range batches {
+		send(id)
+	}
+	if ctx.Err() != nil {
+		failed = ctx.Err()
+	}
+	n.logger.Info("handed off data before leaving", F("keys", moved))
+
+	for id, address := range n.registry.GetAll() {
+		if id == n.ID {
+			continue
+		}
+		err := n.deregisterNodeRemote(ctx, address)
+		if err != nil {
+			n.logger.Warn("unable to leave peer", F(peerField, address), F(errorField, err))
+			failed = err
+		}
+	}
+	if failed != nil {
+		n.logger.Warn("left cluster with incomplete handoff", F(errorField, failed))
+	}
+	return failed
+}
+
+func (n *Node) flush() error {
+	var failed error
+	if flusher, ok := n.store.(Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			failed = err
+		}
+	}
+	if err := n.changes.close(); err != nil {
+		failed = err
+	}
+	if closer, ok := n.tracer.exporter.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+func (n *Node) deregisterNode(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.QueryParameter(idParam))
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	address := n.registry.Get(id)
+	n.registry.Delete(id)
+	n.namespaces.removePeer(id)
+	n.logger.Info("deregistered node", F(peerField, address), F("peer_id", id))
+}
+
+func (n *Node) deregisterNodeRemote(ctx context.Context, address string) error {
+	uri := address + registerPath + "?" + idParam + "=" + strconv.Itoa(n.ID)
+	n.logger.Debug("sending deregister request", F(peerField, uri))
+	statusCode, _, err := n.send(ctx, "DELETE", uri, "", buildPeerHeader([]int{n.ID}, 0))
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code '%d' deregistering from '%s'", statusCode, address)
+	}
+	return nil
+}
+
+func (n *Node) rejectWhileDraining(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	method := request.Request.Method
+	write := method == "PUT" || method == "DELETE" || (method == "POST" && strings.HasSuffix(request.SelectedRoutePath(), batchPutPath))
+	if write && !n.isPeer(request) {
+		if _, _, draining := n.state.get(); draining {
+			response.AddHeader("Retry-After", "1")
+			response.WriteErrorString(http.StatusServiceUnavailable, "node is shutting down")
+			return
+		}
+	}
+	chain.ProcessFilter(request, response)
+}
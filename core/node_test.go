@@ -1,11 +1,21 @@
 package corduroy
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestPing(t *testing.T) {
@@ -22,19 +32,706 @@ func TestNodePutGetEntity(t *testing.T) {
 	payload := "bar"
 	entity := newTestObject(payload)
 	b, err := json.Marshal(entity)
-	_, _, err = node.putValueRemote(node.Address, key, string(b), []int{node.ID}, redundantCopies)
+	_, _, err = node.putValueRemote(context.Background(), node.Address, key, string(b), []int{node.ID}, redundantCopies)
 	assert.NoError(t, err)
-	_, body, err := node.getValueRemote(node.Address, key, []int{node.ID}, redundantCopies)
+	_, body, err := node.getValueRemote(context.Background(), node.Address, key, []int{node.ID}, redundantCopies)
 	storedEntity := &testObject{}
 	err = json.Unmarshal([]byte(body), storedEntity)
 	assert.NoError(t, err)
 	assert.Equal(t, payload, storedEntity.Payload)
 }
 
+func TestNodePutExpiry(t *testing.T) {
+	node := createTestNode()
+	uri := node.Address + entitiesPath + "/session"
+	request, err := http.NewRequest("PUT", uri, strings.NewReader("{}"))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(ttlHeader, "300ms")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	statusCode, body, err := node.getValueRemote(context.Background(), node.Address, "session", []int{node.ID}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "{}", body)
+
+	time.Sleep(time.Millisecond * 400)
+	statusCode, _, err = node.getValueRemote(context.Background(), node.Address, "session", []int{node.ID}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	assert.Equal(t, "", node.Get("session"))
+}
+
+func TestNodePurgeExpired(t *testing.T) {
+	node := createTestNode()
+	node.PutWithTTL("short", "lived", time.Millisecond)
+	node.PutWithTTL("long", "lived", time.Hour)
+	time.Sleep(time.Millisecond * 5)
+	node.purgeExpired()
+	assert.False(t, node.store.Contains("short"))
+	assert.True(t, node.store.Contains("long"))
+}
+
+func TestClusterPutExpiryReplicated(t *testing.T) {
+	cluster := createTestCluster(3)
+	expiry := time.Now().Add(time.Hour)
+	_, _, err := cluster[0].putEntryRemote(context.Background(), cluster[0].Address, "foo", &Entry{Value: []byte("bar"), Expiry: expiry}, []int{cluster[0].ID}, 2)
+	assert.NoError(t, err)
+	copies := 0
+	for _, node := range cluster {
+		entry := node.store.GetEntry("foo")
+		if entry != nil {
+			copies++
+			assert.True(t, expiry.Equal(entry.Expiry))
+		}
+	}
+	assert.True(t, copies >= 2)
+}
+
+func TestClusterDeleteEntity(t *testing.T) {
+	cluster := createTestCluster(3)
+	_, _, err := cluster[0].putValueRemote(context.Background(), cluster[0].Address, "foo", "bar", []int{cluster[0].ID}, 2)
+	assert.NoError(t, err)
+	statusCode, _, err := cluster[0].deleteValueRemote(context.Background(), cluster[0].Address, "foo", []int{cluster[0].ID}, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	for _, node := range cluster {
+		assert.False(t, node.store.Contains("foo"))
+	}
+}
+
+func TestNodeConditionalPut(t *testing.T) {
+	node := createTestNode()
+	uri := node.Address + entitiesPath + "/counter"
+	statusCode, header := sendTestHeaders(t, "PUT", uri, "1", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	etag := header.Get("ETag")
+	assert.NotEqual(t, "", etag)
+	statusCode, _ = sendTestHeaders(t, "PUT", uri, "2", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, statusCode)
+
+	statusCode, header = sendTestHeaders(t, "GET", uri, "", nil)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, etag, header.Get("ETag"))
+	statusCode, _ = sendTestHeaders(t, "GET", uri, "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, statusCode)
+
+	statusCode, _ = sendTestHeaders(t, "PUT", uri, "2", map[string]string{"If-Match": "\"1\""})
+	assert.Equal(t, http.StatusPreconditionFailed, statusCode)
+	statusCode, header = sendTestHeaders(t, "PUT", uri, "2", map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.NotEqual(t, etag, header.Get("ETag"))
+	assert.Equal(t, "2", node.Get("counter"))
+}
+
+func TestClusterConditionalPutForwarded(t *testing.T) {
+	cluster := createTestCluster(3)
+	key := "lock"
+	owner := cluster[0].bestMatch(key, []int{})
+	entries := make([]*Node, 0)
+	for _, node := range cluster {
+		if node.ID != owner {
+			entries = append(entries, node)
+		}
+	}
+
+	statusCode, header := sendTestHeaders(t, "PUT", entries[0].Address + entitiesPath + "/" + key, "a", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	etag := header.Get("ETag")
+	assert.NotEqual(t, "", etag)
+	statusCode, _ = sendTestHeaders(t, "PUT", entries[1].Address + entitiesPath + "/" + key, "b", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, statusCode)
+	statusCode, _ = sendTestHeaders(t, "PUT", entries[1].Address + entitiesPath + "/" + key, "b", map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.False(t, entries[1].store.Contains(key))
+	for _, node := range cluster {
+		if node.ID == owner {
+			assert.Equal(t, "b", node.Get(key))
+		}
+	}
+}
+
+func TestNodeIgnoresStaleReplica(t *testing.T) {
+	node := createTestNode()
+	node.Put("foo", "new")
+	current := node.store.GetEntry("foo")
+	applied := node.applyEntry("foo", &Entry{Value: []byte("old"), Version: current.Version - 1})
+	assert.False(t, applied)
+	assert.Equal(t, "new", node.Get("foo"))
+	applied = node.applyEntry("foo", &Entry{Value: []byte("newer"), Version: current.Version + 1})
+	assert.True(t, applied)
+	assert.Equal(t, "newer", node.Get("foo"))
+}
+
+func TestNodeRejectsClientVersion(t *testing.T) {
+	node := createTestNode()
+	uri := node.Address + entitiesPath + "/pinned"
+	statusCode, _ := sendTestHeaders(t, "PUT", uri, "value", map[string]string{versionHeader: "18446744073709551615"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = sendTestHeaders(t, "DELETE", uri, "", map[string]string{versionHeader: "18446744073709551615"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	assert.False(t, node.store.Contains("pinned"))
+}
+
+func TestClusterDeleteTombstone(t *testing.T) {
+	cluster := createTestCluster(3)
+	cluster[0].Put("gone", "value")
+	stale := cluster[0].store.GetEntry("gone")
+	if !assert.NotNil(t, stale) {
+		return
+	}
+	stale = &Entry{Value: stale.Value, Version: stale.Version}
+
+	owner := cluster[0].bestMatch("gone", []int{})
+	for _, node := range cluster {
+		if node.ID != owner {
+			node.Delete("gone")
+			break
+		}
+	}
+	for _, node := range cluster {
+		assert.False(t, node.store.Contains("gone"))
+	}
+
+	statusCode, _, err := cluster[0].putEntryRemote(context.Background(), cluster[0].registry.Get(owner), "gone", stale, []int{cluster[0].ID}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, statusCode)
+	for _, node := range cluster {
+		assert.False(t, node.store.Contains("gone"))
+	}
+	assert.False(t, cluster[0].applyEntry("gone", &Entry{Value: []byte("expired"), Version: stale.Version + 1, Expiry: time.Now().Add(-time.Second)}))
+
+	cluster[0].Put("gone", "again")
+	assert.Equal(t, "again", cluster[0].Get("gone"))
+}
+
+func TestExpireKeepsNewerWrite(t *testing.T) {
+	node := createTestNode()
+	node.store.PutEntry("session", &Entry{Value: []byte("old"), Version: 1, Expiry: time.Now().Add(-time.Second)})
+	node.Put("session", "new")
+	node.expireEntry("session", 1)
+	assert.Equal(t, "new", node.Get("session"))
+
+	node.store.PutEntry("live", &Entry{Value: []byte("live"), Version: 2, Expiry: time.Now().Add(time.Minute)})
+	node.expireEntry("live", 2)
+	assert.True(t, node.store.Contains("live"))
+	assert.Equal(t, uint64(0), node.tombstones.version("live"))
+}
+
+func TestClusterBatchPutGet(t *testing.T) {
+	cluster := createTestCluster(4)
+	entries := make([]BatchEntry, 0)
+	keys := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		key := "batch-" + strconv.Itoa(i)
+		entries = append(entries, BatchEntry{Key: key, Value: strconv.Itoa(i)})
+		keys = append(keys, key)
+	}
+	entries = append(entries, BatchEntry{Key: "batch-0", Value: "again", IfNoneMatch: "*"})
+	results := cluster[0].BatchPut(entries)
+	assert.Equal(t, len(entries), len(results))
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, http.StatusOK, results[i].Status)
+		assert.NotEqual(t, "", results[i].ETag)
+	}
+
+	keys = append(keys, "batch-missing")
+	results = cluster[2].BatchGet(keys)
+	assert.Equal(t, len(keys), len(results))
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, keys[i], results[i].Key)
+		assert.Equal(t, http.StatusOK, results[i].Status)
+		assert.Equal(t, strconv.Itoa(i), string(results[i].Value))
+	}
+	assert.Equal(t, http.StatusNotFound, results[20].Status)
+}
+
+func TestClusterBatchPartialFailure(t *testing.T) {
+	cluster := createTestCluster(3)
+	stopped := cluster[2]
+	stopped.Stop()
+
+	batch := &batchRequest{}
+	for i := 0; i < 30; i++ {
+		batch.Entries = append(batch.Entries, batchItem{Key: "partial-" + strconv.Itoa(i), Value: []byte("v")})
+	}
+	b, err := json.Marshal(batch)
+	assert.NoError(t, err)
+	request, err := http.NewRequest("POST", cluster[0].Address + batchPutPath, strings.NewReader(string(b)))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	results := &batchResponse{}
+	err = json.NewDecoder(response.Body).Decode(results)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, len(results.Results))
+	failed := 0
+	for i, result := range results.Results {
+		assert.Equal(t, batch.Entries[i].Key, result.Key)
+		owner := cluster[0].bestMatch(result.Key, []int{})
+		if owner == stopped.ID {
+			assert.Equal(t, http.StatusBadGateway, result.Status)
+			failed++
+		}
+	}
+	assert.True(t, failed < 30)
+}
+
+func TestNodeScanCursor(t *testing.T) {
+	node := createTestNode()
+	for _, key := range []string{"b", "a", "c", "d", "other"} {
+		node.Put(key, key)
+	}
+	err := node.CreateNamespace(&Namespace{Name: "hidden", Replicas: 1})
+	assert.NoError(t, err)
+	node.Put(namespaceKey("hidden", "a"), "a")
+
+	result, err := node.ScanKeys("", "", 2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, result.Keys)
+	node.Delete("a")
+	node.Put("bb", "bb")
+	result, err = node.ScanKeys("", result.Cursor, 2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bb", "c"}, result.Keys)
+	result, err = node.ScanKeys("", result.Cursor, 2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "other"}, result.Keys)
+	result, err = node.ScanKeys("", result.Cursor, 2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(result.Keys))
+	assert.Equal(t, "", result.Cursor)
+
+	response, err := http.Get(node.Address + namespacesPath + "/hidden" + entitiesPath)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	scan := &ScanResult{}
+	err = json.NewDecoder(response.Body).Decode(scan)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, scan.Keys)
+}
+
+func TestClusterScan(t *testing.T) {
+	cluster := createTestCluster(3)
+	keys := make([]string, 0)
+	for i := 0; i < 25; i++ {
+		key := "scan-" + strconv.Itoa(100 + i)
+		keys = append(keys, key)
+		_, _, err := cluster[0].putValueRemote(context.Background(), cluster[0].Address, key, "v", []int{cluster[0].ID}, 2)
+		assert.NoError(t, err)
+	}
+
+	scanned := make([]string, 0)
+	cursor := ""
+	for {
+		uri := cluster[1].Address + entitiesPath + "?scope=cluster&prefix=scan-&limit=10&cursor=" + cursor
+		response, err := http.Get(uri)
+		assert.NoError(t, err)
+		result := &ScanResult{}
+		err = json.NewDecoder(response.Body).Decode(result)
+		response.Body.Close()
+		assert.NoError(t, err)
+		scanned = append(scanned, result.Keys...)
+		if result.Cursor == "" {
+			break
+		}
+		cursor = result.Cursor
+	}
+	assert.Equal(t, keys, scanned)
+}
+
+func TestClusterRange(t *testing.T) {
+	cluster := createTestCluster(3)
+	for i := 0; i < 20; i++ {
+		key := "range-" + strconv.Itoa(10 + i)
+		_, _, err := cluster[0].putValueRemote(context.Background(), cluster[0].Address, key, strconv.Itoa(i), []int{cluster[0].ID}, 2)
+		assert.NoError(t, err)
+	}
+
+	items, err := cluster[1].Range("range-12", "range-20", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, len(items))
+	for i, item := range items {
+		assert.Equal(t, "range-" + strconv.Itoa(12 + i), item.Key)
+		assert.Equal(t, strconv.Itoa(2 + i), string(item.Value))
+	}
+
+	response, err := http.Get(cluster[2].Address + rangePath + "?start=range-&limit=5")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, ndjsonMime, response.Header.Get("Content-Type"))
+	decoder := json.NewDecoder(response.Body)
+	keys := make([]string, 0)
+	for decoder.More() {
+		item := &RangeItem{}
+		assert.NoError(t, decoder.Decode(item))
+		keys = append(keys, item.Key)
+	}
+	assert.Equal(t, []string{"range-10", "range-11", "range-12", "range-13", "range-14"}, keys)
+}
+
+func TestClusterWatch(t *testing.T) {
+	cluster := createTestCluster(3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := cluster[0].Watch(ctx, "watch-")
+	time.Sleep(time.Millisecond * 100)
+
+	for i := 0; i < 3; i++ {
+		_, _, err := cluster[1].putValueRemote(context.Background(), cluster[1].Address, "watch-" + strconv.Itoa(i), strconv.Itoa(i), []int{cluster[1].ID}, 2)
+		assert.NoError(t, err)
+	}
+	_, _, err := cluster[1].putValueRemote(context.Background(), cluster[1].Address, "other", "ignored", []int{cluster[1].ID}, 2)
+	assert.NoError(t, err)
+	_, _, err = cluster[2].deleteValueRemote(context.Background(), cluster[2].Address, "watch-1", []int{cluster[2].ID}, 2, 0)
+	assert.NoError(t, err)
+
+	received := make(map[string]string)
+	for i := 0; i < 4; i++ {
+		select {
+		case event := <-events:
+			received[event.Key + ":" + event.Type] = string(event.Value)
+			assert.Equal(t, cluster[0].bestMatch(event.Key, []int{}), event.Node)
+		case <-time.After(time.Second * 2):
+			t.Fatal("timed out waiting for watch events")
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"watch-0:put": "0",
+		"watch-1:put": "1",
+		"watch-2:put": "2",
+		"watch-1:delete": "",
+	}, received)
+}
+
+func TestNodeWatchReconnect(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", eventStreamMime)
+		if attempt == 1 {
+			fmt.Fprint(w, "data: {\"node\": 7, \"revision\": 1, \"type\": \"put\", \"key\": \"watch-a\"}\n\n")
+			return
+		}
+		assert.Equal(t, "1", r.URL.Query().Get(sinceParam))
+		fmt.Fprint(w, "data: {\"node\": 7, \"revision\": 2, \"type\": \"put\", \"key\": \"watch-b\"}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	node := createTestNode()
+	node.registry.Put(7, server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := node.Watch(ctx, "watch-")
+	for _, key := range []string{"watch-a", "watch-b"} {
+		select {
+		case event, ok := <-events:
+			assert.True(t, ok)
+			assert.Equal(t, key, event.Key)
+		case <-time.After(time.Second * 2):
+			t.Fatal("timed out waiting for watch events")
+		}
+	}
+}
+
+func TestNodeWatchResume(t *testing.T) {
+	node := createTestNode()
+	node.Put("sse-a", "1")
+
+	first := readTestEvents(t, node.Address + watchPath + "?prefix=sse-", "", 1, func() {
+		node.Put("sse-b", "2")
+	})
+	assert.Equal(t, "sse-b", first[0].event.Key)
+	node.Put("sse-c", "3")
+	node.Put("ignored", "4")
+	node.Delete("sse-b")
+
+	resumed := readTestEvents(t, node.Address + watchPath + "?prefix=sse-", first[0].id, 2, func() {})
+	assert.Equal(t, "sse-c", resumed[0].event.Key)
+	assert.Equal(t, PutEvent, resumed[0].event.Type)
+	assert.Equal(t, "sse-b", resumed[1].event.Key)
+	assert.Equal(t, DeleteEvent, resumed[1].event.Type)
+}
+
+func TestWatchHubCompacted(t *testing.T) {
+	hub := newWatchHub(1)
+	for i := 0; i < watchHistorySize + 10; i++ {
+		hub.publish(PutEvent, "key", &Entry{Value: []byte(strconv.Itoa(i))})
+	}
+	_, err := hub.subscribe("", 5, true)
+	assert.Equal(t, ErrRevisionCompacted, err)
+	w, err := hub.subscribe("", hub.currentRevision() - 1, true)
+	assert.NoError(t, err)
+	event := <-w.events
+	assert.Equal(t, hub.currentRevision(), event.Revision)
+	hub.unsubscribe(w)
+}
+
+type testEvent struct {
+	id    string
+	event Event
+}
+
+func readTestEvents(t *testing.T, uri string, lastEventID string, count int, trigger func()) []testEvent {
+	request, err := http.NewRequest("GET", uri, nil)
+	assert.NoError(t, err)
+	if lastEventID != "" {
+		request.Header.Set(lastEventIDHeader, lastEventID)
+	}
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, eventStreamMime, response.Header.Get("Content-Type"))
+	trigger()
+
+	events := make([]testEvent, 0, count)
+	scanner := bufio.NewScanner(response.Body)
+	id := ""
+	for len(events) < count && scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimPrefix(line, "id: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			event := Event{}
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+			events = append(events, testEvent{id: id, event: event})
+		}
+	}
+	assert.Equal(t, count, len(events))
+	return events
+}
+
+func TestClusterChanges(t *testing.T) {
+	cluster := createTestCluster(3)
+	_, _, err := cluster[0].putValueRemote(context.Background(), cluster[0].Address, "cdc", "1", []int{cluster[0].ID}, 2)
+	assert.NoError(t, err)
+	owner := cluster[0].registry.Get(cluster[0].bestMatch("cdc", []int{}))
+	_, _, err = cluster[0].deleteValueRemote(context.Background(), owner, "cdc", []int{}, 2, 0)
+	assert.NoError(t, err)
+
+	origins := make(map[string]int)
+	for _, node := range cluster {
+		changes, err := node.Changes(0, 0)
+		assert.NoError(t, err)
+		for i, change := range changes {
+			assert.Equal(t, uint64(i + 1), change.Sequence)
+			origins[change.Type + ":" + change.Origin]++
+		}
+	}
+	assert.Equal(t, 1, origins["put:" + ClientOrigin])
+	assert.True(t, origins["put:" + ReplicationOrigin] >= 1)
+	assert.Equal(t, 1, origins["delete:" + ClientOrigin])
+	assert.True(t, origins["delete:" + ReplicationOrigin] >= 1)
+}
+
+func TestNodeChangesFollow(t *testing.T) {
+	node := createTestNode()
+	node.Put("first", "1")
+	node.Put("second", "2")
+
+	response, err := http.Get(node.Address + changesPath + "?since=1&follow=true&limit=2")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, ndjsonMime, response.Header.Get("Content-Type"))
+	node.Delete("first")
+
+	decoder := json.NewDecoder(response.Body)
+	changes := make([]*Change, 0)
+	for decoder.More() {
+		change := &Change{}
+		assert.NoError(t, decoder.Decode(change))
+		changes = append(changes, change)
+	}
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, "second", changes[0].Key)
+	assert.Equal(t, uint64(3), changes[1].Sequence)
+	assert.Equal(t, DeleteEvent, changes[1].Type)
+	assert.Equal(t, ClientOrigin, changes[1].Origin)
+}
+
+func TestClusterMetrics(t *testing.T) {
+	cluster := createTestCluster(2)
+	cluster[0].Put("metrics", "value")
+	_, _, err := cluster[1].getValueRemote(context.Background(), cluster[1].Address, "missing", []int{}, 1)
+	assert.NoError(t, err)
+
+	response, err := http.Get(cluster[1].Address + metricsPath)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, metricsMime, response.Header.Get("Content-Type"))
+	b, err := ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	body := string(b)
+	assert.Contains(t, body, `corduroy_http_requests_total{code="404",method="GET",route="/entities/{key}"} 1`)
+	assert.Contains(t, body, `corduroy_http_request_duration_seconds_count{method="GET",route="/entities/{key}"}`)
+	assert.Contains(t, body, "corduroy_registry_nodes 2")
+	assert.Contains(t, body, `corduroy_peer_requests_total{peer="` + strconv.Itoa(cluster[0].ID) + `"}`)
+
+	families := cluster[0].Metrics()
+	found := false
+	for _, family := range families {
+		if family.Name == "corduroy_store_keys" {
+			found = true
+			assert.Equal(t, float64(cluster[0].store.Size()), family.Samples[0].Value)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestClusterReplicationLag(t *testing.T) {
+	cluster := createTestCluster(2)
+	cluster[0].Put("lag", "value")
+	cluster[0].updateRandomValue()
+	cluster[0].updateRandomValue()
+	assert.True(t, cluster[1].store.Contains("lag"))
+	var b strings.Builder
+	assert.NoError(t, cluster[1].WriteMetrics(&b))
+	assert.NotContains(t, b.String(), "corduroy_replication_lag_seconds_count")
+
+	owner, replica := cluster[1], cluster[0]
+	if cluster[0].bestMatch("lag", []int{}) == cluster[0].ID {
+		owner, replica = cluster[0], cluster[1]
+	}
+	_, _, err := owner.putValueRemote(context.Background(), owner.Address, "lag", "again", []int{}, 1)
+	assert.NoError(t, err)
+	b.Reset()
+	assert.NoError(t, replica.WriteMetrics(&b))
+	assert.Contains(t, b.String(), "corduroy_replication_lag_seconds_count 1")
+}
+
+func TestClusterAdmin(t *testing.T) {
+	cluster := createTestCluster(3)
+	node := cluster[0]
+
+	segments := node.Ring()
+	assert.Equal(t, 3, len(segments))
+	percent := 0.0
+	next := 0
+	for _, segment := range segments {
+		assert.Equal(t, next, segment.Start)
+		next = segment.End + 1
+		percent += segment.Percent
+	}
+	assert.Equal(t, ringSize, next)
+	assert.InDelta(t, 100, percent, 0.0001)
+
+	for i := 0; i < 20; i++ {
+		key := "locate-" + strconv.Itoa(i)
+		location := node.Locate(key)
+		assert.Equal(t, node.bestMatch(key, []int{}), location.Owner)
+		assert.Equal(t, location.Owner, location.Replicas[0])
+		assert.Equal(t, 3, len(location.Replicas))
+		for _, segment := range segments {
+			if segment.ID == location.Owner {
+				assert.True(t, location.Hash >= segment.Start && location.Hash <= segment.End)
+			}
+		}
+	}
+
+	response, err := http.Get(node.Address + locatePath + "?key=foo")
+	assert.NoError(t, err)
+	location := &KeyLocation{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(location))
+	response.Body.Close()
+	assert.Equal(t, hash("foo"), location.Hash)
+	assert.Equal(t, 3, len(location.Addresses))
+
+	response, err = http.Get(node.Address + statusPath)
+	assert.NoError(t, err)
+	status := &NodeStatus{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(status))
+	response.Body.Close()
+	assert.Equal(t, node.ID, status.ID)
+	assert.Equal(t, Version, status.Version)
+	assert.Equal(t, 3, status.Nodes)
+	assert.Equal(t, 2, len(status.Peers))
+	assert.True(t, status.Uptime > 0)
+}
+
+type testSpanExporter struct {
+	spans []*Span
+	mux   sync.Mutex
+}
+
+func (te *testSpanExporter) Export(span *Span) error {
+	te.mux.Lock()
+	defer te.mux.Unlock()
+	te.spans = append(te.spans, span)
+	return nil
+}
+
+func (te *testSpanExporter) find(node int, name string) *Span {
+	te.mux.Lock()
+	defer te.mux.Unlock()
+	for _, span := range te.spans {
+		if span.Node == node && span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestClusterTracing(t *testing.T) {
+	cluster := createTestCluster(2)
+	exporter := &testSpanExporter{}
+	for _, node := range cluster {
+		node.UseTracing(exporter)
+	}
+	owner, other := cluster[0], cluster[1]
+	if owner.bestMatch("traced", []int{}) != owner.ID {
+		owner, other = other, owner
+	}
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	statusCode, header := sendTestHeaders(t, "PUT", other.Address + entitiesPath + "/traced", "value", map[string]string{
+		traceparentHeader: "00-" + traceID + "-00f067aa0ba902b7-01",
+	})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, traceID, header.Get(traceIDHeader))
+
+	time.Sleep(time.Millisecond * 50)
+	handler := exporter.find(other.ID, "PUT /entities/{key}")
+	peer := exporter.find(other.ID, "peer PUT")
+	forwarded := exporter.find(owner.ID, "PUT /entities/{key}")
+	assert.NotNil(t, handler)
+	assert.NotNil(t, peer)
+	assert.NotNil(t, forwarded)
+	assert.Equal(t, traceID, handler.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", handler.ParentID)
+	assert.Equal(t, handler.SpanID, peer.ParentID)
+	assert.Equal(t, peer.SpanID, forwarded.ParentID)
+	assert.Equal(t, traceID, forwarded.TraceID)
+}
+
+func sendTestHeaders(t *testing.T, verb string, uri string, body string, headers map[string]string) (int, http.Header) {
+	request, err := http.NewRequest(verb, uri, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	return response.StatusCode, response.Header
+}
+
 func TestNodeGetNotFound(t *testing.T) {
 	node := createTestNode()
 	key := "foo"
-	statusCode, body, err := node.getValueRemote(node.Address, key, []int{node.ID}, redundantCopies)
+	statusCode, body, err := node.getValueRemote(context.Background(), node.Address, key, []int{node.ID}, redundantCopies)
 	assert.NoError(t, err)
 	assert.Equal(t, "", body)
 	assert.Equal(t, http.StatusNotFound, statusCode)
@@ -57,9 +754,9 @@ func TestClusterPutGetEntity(t *testing.T) {
 	payload := "bar"
 	entity := newTestObject(payload)
 	b, err := json.Marshal(entity)
-	_, _, err = cluster[0].putValueRemote(cluster[1].Address, key, string(b), []int{cluster[0].ID}, redundantCopies)
+	_, _, err = cluster[0].putValueRemote(context.Background(), cluster[1].Address, key, string(b), []int{cluster[0].ID}, redundantCopies)
 	assert.NoError(t, err)
-	_, body, err := cluster[3].getValueRemote(cluster[4].Address, key, []int{cluster[3].ID}, redundantCopies)
+	_, body, err := cluster[3].getValueRemote(context.Background(), cluster[4].Address, key, []int{cluster[3].ID}, redundantCopies)
 	storedEntity := &testObject{}
 	err = json.Unmarshal([]byte(body), storedEntity)
 	assert.NoError(t, err)
@@ -74,6 +771,1098 @@ func TestClusterDetectStoppedNode(t *testing.T) {
 	assert.False(t, cluster[0].registry.Contains(cluster[1].ID))
 }
 
+func TestNodeConnectSeeds(t *testing.T) {
+	seed := createTestNode()
+	node := createTestNode()
+	err := node.Connect("http://localhost:1/missing", seed.Address)
+	assert.NoError(t, err)
+	assert.True(t, node.registry.Contains(seed.ID))
+	assert.True(t, seed.registry.Contains(node.ID))
+}
+
+func TestNodeConnectSelfSeed(t *testing.T) {
+	node := createTestNode()
+	u, err := url.Parse(node.Address)
+	assert.NoError(t, err)
+	node.seeds = []string{"http://127.0.0.1:" + u.Port() + u.Path}
+	err = node.connectOnce()
+	assert.Error(t, err)
+	assert.Equal(t, 1, node.registry.Size())
+	assert.Equal(t, node.Address, node.registry.Get(node.ID))
+}
+
+func TestNodeConnectStopped(t *testing.T) {
+	node := createTestNode()
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		node.Stop()
+	}()
+	err := node.Connect("http://localhost:1/missing")
+	assert.Error(t, err)
+}
+
+func TestNodeReadiness(t *testing.T) {
+	seed := createTestNode()
+	for i := 0; i < 20; i++ {
+		seed.Put("handoff-" + strconv.Itoa(i), strconv.Itoa(i))
+	}
+	statusCode, _ := sendTestHeaders(t, "GET", seed.Address + readyzPath, "", nil)
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	isolated := createTestNode()
+	go isolated.Connect("http://localhost:1/missing")
+	time.Sleep(time.Millisecond * 50)
+	statusCode, _ = sendTestHeaders(t, "GET", isolated.Address + readyzPath, "", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.False(t, isolated.Readiness().Joined)
+	statusCode, _ = sendTestHeaders(t, "GET", isolated.Address + healthzPath, "", nil)
+	assert.Equal(t, http.StatusOK, statusCode)
+	isolated.Stop()
+
+	joining := createTestNode()
+	err := joining.Connect(seed.Address)
+	assert.NoError(t, err)
+	readiness := joining.Readiness()
+	assert.True(t, readiness.Ready)
+	assert.True(t, readiness.HandedOff)
+	assert.Equal(t, 20, joining.store.Size())
+	assert.Equal(t, "7", joining.Get("handoff-7"))
+
+	seed.Drain()
+	statusCode, _ = sendTestHeaders(t, "GET", seed.Address + readyzPath, "", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.True(t, seed.Readiness().Draining)
+}
+
+func TestNodeGracefulShutdown(t *testing.T) {
+	cluster := createTestCluster(3)
+	leaving := cluster[2]
+	leaving.store.PutEntry("only-here", &Entry{Value: []byte("kept"), Version: 1})
+
+	leaving.Drain()
+	statusCode := sendTestBody(t, "PUT", leaving.Address + entitiesPath + "/rejected", "value")
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second * 5)
+	defer cancel()
+	err := leaving.Shutdown(ctx)
+	assert.NoError(t, err)
+	_, _, err = cluster[0].pingRemote(leaving.Address)
+	assert.Error(t, err)
+
+	for _, node := range cluster[:2] {
+		assert.False(t, node.registry.Contains(leaving.ID))
+		assert.Equal(t, "kept", node.Get("only-here"))
+	}
+}
+
+func TestClusterWireProtocol(t *testing.T) {
+	cluster := createTestCluster(3)
+	for _, node := range cluster {
+		node.UseWireProtocol(true)
+	}
+	for i := 0; i < 10; i++ {
+		key := "wire-" + strconv.Itoa(i)
+		assert.Equal(t, http.StatusOK, sendTestBody(t, "PUT", cluster[i % 3].Address + entitiesPath + "/" + key, "v" + strconv.Itoa(i)))
+	}
+	results := cluster[1].BatchGet([]string{"wire-3", "wire-7"})
+	assert.Equal(t, "v3", string(results[0].Value))
+	assert.Equal(t, "v7", string(results[1].Value))
+
+	served := 0.0
+	for _, node := range cluster {
+		statusCode, body, err := node.getValueRemote(context.Background(), node.Address, "wire-5", []int{}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "v5", body)
+		for _, sample := range node.metrics.wireRequests.collect().Samples {
+			served += sample.Value
+		}
+	}
+	assert.True(t, served > 0)
+	assert.True(t, cluster[0].wire.connections() > 0)
+
+	old := createTestNode()
+	cluster[0].registry.Put(old.ID, old.Address)
+	statusCode, _, err := cluster[0].pingRemote(old.Address)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	_, err = cluster[0].wire.get(context.Background(), old.Address)
+	assert.Equal(t, errWireUnsupported, err)
+}
+
+func TestNodeCoalescesForwardedReads(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("ETag", formatETag(1))
+		w.Write([]byte("herd"))
+	}))
+	defer server.Close()
+
+	node := createTestNode()
+	node.registry.Put(hash(server.URL), server.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statusCode, body, err := node.getValueRemote(context.Background(), node.Address, "herd", []int{}, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, statusCode)
+			assert.Equal(t, "herd", body)
+		}()
+	}
+	time.Sleep(time.Millisecond * 100)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, float64(9), node.metrics.coalesced.get(coalescedPeer))
+}
+
+func TestForwardGetClonesHeaders(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("ETag", formatETag(1))
+		w.Write([]byte("herd"))
+	}))
+	defer server.Close()
+
+	node := createTestNode()
+	headers := make([]http.Header, 2)
+	var wg sync.WaitGroup
+	for i := range headers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statusCode, _, header, err := node.forwardGet(context.Background(), server.URL+"/herd", http.Header{})
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, statusCode)
+			headers[i] = header
+		}(i)
+	}
+	time.Sleep(time.Millisecond * 100)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, float64(1), node.metrics.coalesced.get(coalescedPeer))
+	headers[0].Set("ETag", formatETag(2))
+	assert.Equal(t, formatETag(1), headers[1].Get("ETag"))
+}
+
+type slowTestStore struct {
+	*MemoryStore
+	reads   int32
+	release chan struct{}
+}
+
+func (s *slowTestStore) GetEntry(key string) *Entry {
+	atomic.AddInt32(&s.reads, 1)
+	<-s.release
+	return s.MemoryStore.GetEntry(key)
+}
+
+func TestNodeCoalescesStoreReads(t *testing.T) {
+	store := &slowTestStore{MemoryStore: NewMemoryStore(), release: make(chan struct{})}
+	store.MemoryStore.PutEntry("herd", &Entry{Value: []byte("herd"), Version: 1})
+	port := getNextTestPort()
+	node := NewNode(port, "/" + strconv.Itoa(port), store, NewMemoryRegistry())
+	node.UseStoreCoalescing(true)
+	node.Start()
+	for _, ticker := range node.tickers {
+		ticker.Stop()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statusCode, body, err := node.getValueRemote(context.Background(), node.Address, "herd", []int{}, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, statusCode)
+			assert.Equal(t, "herd", body)
+		}()
+	}
+	time.Sleep(time.Millisecond * 100)
+	close(store.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.reads))
+	assert.Equal(t, float64(9), node.metrics.coalesced.get(coalescedStore))
+}
+
+func TestClusterChunkedValues(t *testing.T) {
+	cluster := createTestCluster(3)
+	for _, node := range cluster {
+		node.UseChunking(&ChunkOptions{ChunkSize: 1024, MaxObjectSize: 64 * 1024})
+	}
+	node := cluster[0]
+	parts := make([]string, 0)
+	for i := 0; len(strings.Join(parts, "")) < 10000; i++ {
+		parts = append(parts, strconv.Itoa(i)+",")
+	}
+	value := strings.Join(parts, "")
+	uri := node.Address + entitiesPath + "/big"
+
+	statusCode := sendTestBody(t, "PUT", uri, value)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.True(t, countTestChunks(cluster) >= 10)
+
+	statusCode, body, header := getTestBody(t, uri, map[string]string{hopsHeader: "3"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, value, body)
+	assert.Equal(t, strconv.Itoa(len(value)), header.Get("Content-Length"))
+	assert.Equal(t, "bytes", header.Get("Accept-Ranges"))
+
+	statusCode, body, header = getTestBody(t, uri, map[string]string{hopsHeader: "3", "Range": "bytes=1000-2099"})
+	assert.Equal(t, http.StatusPartialContent, statusCode)
+	assert.Equal(t, value[1000:2100], body)
+	assert.Equal(t, "bytes 1000-2099/" + strconv.Itoa(len(value)), header.Get("Content-Range"))
+
+	statusCode, body, _ = getTestBody(t, uri, map[string]string{hopsHeader: "3", "Range": "bytes=-5"})
+	assert.Equal(t, http.StatusPartialContent, statusCode)
+	assert.Equal(t, value[len(value)-5:], body)
+
+	statusCode, _, _ = getTestBody(t, uri, map[string]string{hopsHeader: "3", "Range": "bytes=99999-"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, statusCode)
+
+	owner := cluster[0]
+	for _, n := range cluster {
+		if n.ID == node.bestMatch("big", []int{}) {
+			owner = n
+		}
+	}
+	assert.True(t, isManifest(owner.store.GetEntry("big").Value))
+	assert.Equal(t, value, owner.Get("big"))
+
+	results := node.BatchGet([]string{"big"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, results[0].Status)
+	assert.Empty(t, results[0].Value)
+	items, err := node.Range("big", "big\x00", 0)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(items)) {
+		assert.Empty(t, items[0].Value)
+		assert.Equal(t, int64(len(value)), items[0].Size)
+	}
+	w, err := owner.watches.subscribe("big", 0, true)
+	assert.NoError(t, err)
+	event := <-w.events
+	owner.watches.unsubscribe(w)
+	assert.Empty(t, event.Value)
+	assert.Equal(t, int64(len(value)), event.Size)
+
+	statusCode = sendTestBody(t, "PUT", uri, "small")
+	assert.Equal(t, http.StatusOK, statusCode)
+	for i := 0; i < 100 && countTestChunks(cluster) > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 0, countTestChunks(cluster))
+
+	statusCode = sendTestBody(t, "PUT", uri, strings.Repeat("x", 65 * 1024))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, statusCode)
+	statusCode = sendTestBody(t, "PUT", uri, manifestPrefix + "{}")
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	for i := 0; i < 100 && countTestChunks(cluster) > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 0, countTestChunks(cluster))
+}
+
+func TestNodeStreamsForwardedValues(t *testing.T) {
+	cluster := createTestCluster(2)
+	owner := cluster[0]
+	if owner.bestMatch("blob", []int{}) != owner.ID {
+		owner = cluster[1]
+	}
+	forwarder := cluster[0]
+	if forwarder == owner {
+		forwarder = cluster[1]
+	}
+	for _, node := range cluster {
+		node.UseChunking(&ChunkOptions{ChunkSize: 16, MaxObjectSize: 1024})
+	}
+	value := strings.Repeat("abcdefghij", 10)
+	owner.Put("blob", value)
+
+	statusCode, _ := sendTestHeaders(t, "GET", owner.Address+entitiesPath+"/blob", "", map[string]string{visitedHeader: "1", sizeLimitHeader: "16"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, statusCode)
+
+	statusCode, body, header := getTestBody(t, forwarder.Address+entitiesPath+"/blob", map[string]string{hopsHeader: "1"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, value, body)
+	assert.NotEqual(t, "", header.Get("ETag"))
+
+	statusCode, body, _ = getTestBody(t, forwarder.Address+entitiesPath+"/blob", map[string]string{hopsHeader: "1", "Range": "bytes=25-34"})
+	assert.Equal(t, http.StatusPartialContent, statusCode)
+	assert.Equal(t, value[25:35], body)
+}
+
+func TestNodeReaderValues(t *testing.T) {
+	cluster := createTestCluster(2)
+	node := cluster[0]
+	node.UseChunking(&ChunkOptions{ChunkSize: 16, MaxObjectSize: 1024})
+	value := strings.Repeat("abcdefghij", 10)
+	err := node.PutReader("reader", strings.NewReader(value))
+	assert.NoError(t, err)
+	assert.True(t, isManifest(node.store.GetEntry("reader").Value))
+
+	reader, err := node.GetReader("reader")
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, value, string(b))
+	for _, n := range cluster {
+		changes, err := n.Changes(0, 1000)
+		assert.NoError(t, err)
+		for _, change := range changes {
+			if change.Namespace == chunkNamespace {
+				assert.Equal(t, SystemOrigin, change.Origin)
+			} else if change.Key == "reader" {
+				assert.Empty(t, change.Value)
+				assert.Equal(t, int64(len(value)), change.Size)
+			}
+		}
+	}
+
+	err = node.PutReader("reader", strings.NewReader(strings.Repeat("x", 2048)))
+	assert.Equal(t, ErrObjectTooLarge, err)
+	_, err = node.GetReader("missing")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func countTestChunks(cluster []*Node) int {
+	count := 0
+	for _, node := range cluster {
+		for _, key := range node.store.GetKeys(0, node.store.Size()) {
+			if name, _ := splitNamespaceKey(key); name == chunkNamespace {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func getTestBody(t *testing.T, uri string, headers map[string]string) (int, string, http.Header) {
+	request, err := http.NewRequest("GET", uri, nil)
+	assert.NoError(t, err)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	return response.StatusCode, string(b), response.Header
+}
+
+func TestClusterBinaryValues(t *testing.T) {
+	cluster := createTestCluster(3)
+	value := "\x89PNG\r\n\x1a\n\x00\xff\xfe"
+	uri := cluster[0].Address + entitiesPath + "/image"
+	request, err := http.NewRequest("PUT", uri, strings.NewReader(value))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "image/png")
+	request.Header.Set(metaHeaderPrefix+"Owner", "alice")
+	request.Header.Set(hopsHeader, "2")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	for _, node := range cluster {
+		statusCode, body, header := getTestBody(t, node.Address+entitiesPath+"/image", map[string]string{hopsHeader: "3", "Accept": "image/png"})
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, value, body)
+		assert.Equal(t, "image/png", header.Get("Content-Type"))
+		assert.Equal(t, "alice", header.Get(metaHeaderPrefix+"Owner"))
+	}
+
+	statusCode := sendTestBody(t, "PUT", cluster[1].Address+entitiesPath+"/plain", "text")
+	assert.Equal(t, http.StatusOK, statusCode)
+	_, body, header := getTestBody(t, cluster[1].Address+entitiesPath+"/plain", map[string]string{hopsHeader: "3"})
+	assert.Equal(t, "text", body)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+
+	results := cluster[2].BatchPut([]BatchEntry{{Key: "blob", Value: value, ContentType: "application/octet-stream", Meta: map[string]string{"Sha": "abc"}}})
+	assert.Equal(t, http.StatusOK, results[0].Status)
+	results = cluster[0].BatchGet([]string{"blob", "image"})
+	assert.Equal(t, value, string(results[0].Value))
+	assert.Equal(t, "application/octet-stream", results[0].ContentType)
+	assert.Equal(t, "abc", results[0].Meta["Sha"])
+	assert.Equal(t, value, string(results[1].Value))
+	assert.Equal(t, "image/png", results[1].ContentType)
+}
+
+func TestClusterCompression(t *testing.T) {
+	cluster := createTestCluster(3)
+	for _, node := range cluster {
+		node.UseCompression(&CompressionOptions{MinSize: 64})
+	}
+	value := "[" + strings.Repeat(`{"name": "corduroy", "kind": "document"},`, 200) + "{}]"
+	uri := cluster[0].Address + entitiesPath + "/doc"
+	statusCode, _ := sendTestHeaders(t, "PUT", uri, value, map[string]string{hopsHeader: "2"})
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	stored, compressed := 0, 0
+	for _, node := range cluster {
+		if entry := node.store.GetEntry("doc"); entry != nil {
+			stored++
+			assert.Equal(t, gzipCodec, entry.Codec)
+			assert.True(t, len(entry.Value) < len(value)/10)
+			assert.Equal(t, value, node.Get("doc"))
+		}
+		if ratio := node.compressionRatio(); ratio > 0 {
+			compressed++
+			assert.True(t, ratio < 0.1)
+		}
+	}
+	assert.True(t, stored >= 2)
+	assert.Equal(t, 1, compressed)
+
+	for _, node := range cluster {
+		statusCode, body, header := getTestBody(t, node.Address+entitiesPath+"/doc", map[string]string{hopsHeader: "3", "Accept-Encoding": "gzip"})
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, gzipCodec, header.Get("Content-Encoding"))
+		assert.Equal(t, "application/json", header.Get("Content-Type"))
+		plain, err := decompressValue([]byte(body))
+		assert.NoError(t, err)
+		assert.Equal(t, value, string(plain))
+
+		statusCode, body, header = getTestBody(t, node.Address+entitiesPath+"/doc", map[string]string{hopsHeader: "3", "Accept-Encoding": "identity"})
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "", header.Get("Content-Encoding"))
+		assert.Equal(t, value, body)
+	}
+
+	statusCode, body, _ := getTestBody(t, uri, map[string]string{hopsHeader: "3", "Accept-Encoding": "gzip", "Range": "bytes=0-9"})
+	assert.Equal(t, http.StatusPartialContent, statusCode)
+	assert.Equal(t, value[:10], body)
+
+	results := cluster[1].BatchGet([]string{"doc"})
+	assert.Equal(t, value, string(results[0].Value))
+
+	port := getNextTestPort()
+	older := NewNode(port, "/" + strconv.Itoa(port), NewMemoryStore(), NewMemoryRegistry())
+	older.protocols = []int{1, 2}
+	older.Start()
+	err := older.Connect(cluster[0].Address)
+	assert.NoError(t, err)
+	entry := &Entry{Value: []byte(value), Version: 1}
+	statusCode, _, err = cluster[0].putEntryRemote(context.Background(), older.Address, "doc", cluster[0].compressEntry("doc", entry), []int{cluster[0].ID}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "", older.store.GetEntry("doc").Codec)
+	assert.Equal(t, []byte(value), older.store.GetEntry("doc").Value)
+}
+
+func TestNamespaceCompression(t *testing.T) {
+	node := createTestNode()
+	err := node.CreateNamespace(&Namespace{Name: "docs", Replicas: 1, Compress: true})
+	assert.NoError(t, err)
+	value := strings.Repeat("compressible ", 200)
+	node.Put(namespaceKey("docs", "a"), value)
+	node.Put("a", value)
+	assert.Equal(t, gzipCodec, node.store.GetEntry(namespaceKey("docs", "a")).Codec)
+	assert.Equal(t, "", node.store.GetEntry("a").Codec)
+	assert.Equal(t, value, node.Get(namespaceKey("docs", "a")))
+}
+
+func TestClusterMixedProtocols(t *testing.T) {
+	newer := createTestNode()
+	port := getNextTestPort()
+	older := NewNode(port, "/" + strconv.Itoa(port), NewMemoryStore(), NewMemoryRegistry())
+	older.protocols = []int{1}
+	older.Start()
+
+	err := older.Connect(newer.Address)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, newer.peers.protocol(older.ID))
+	assert.Equal(t, 1, older.peers.protocol(newer.ID))
+
+	request, err := http.NewRequest("GET", newer.Address + nodesPath, nil)
+	assert.NoError(t, err)
+	request.Header = buildPeerHeader([]int{older.ID}, 0)
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	nodes := map[int]string{}
+	err = json.NewDecoder(response.Body).Decode(&nodes)
+	response.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, older.Address, nodes[older.ID])
+	assert.Equal(t, "", response.Header.Get(protocolHeader))
+
+	err = newer.syncNodeRegistryRemote(older.Address)
+	assert.NoError(t, err)
+	err = older.syncNodeRegistryRemote(newer.Address)
+	assert.NoError(t, err)
+	newer.Put("mixed", "value")
+	statusCode, body, err := older.getValueRemote(context.Background(), newer.Address, "mixed", []int{older.ID}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "value", body)
+
+	current := createTestNode()
+	err = current.Connect(newer.Address)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, newer.peers.protocol(current.ID))
+	assert.Equal(t, 3, current.peers.protocol(newer.ID))
+	assert.Equal(t, 1, current.peers.protocol(older.ID))
+
+	port = getNextTestPort()
+	strict := NewNode(port, "/" + strconv.Itoa(port), NewMemoryStore(), NewMemoryRegistry())
+	err = strict.UseMinProtocol(2)
+	assert.NoError(t, err)
+	strict.Start()
+	err = older.registerNodeRemote(strict.Address)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "protocol version '1' is not supported")
+	assert.False(t, strict.registry.Contains(older.ID))
+	assert.Error(t, strict.UseMinProtocol(4))
+}
+
+type legacyTestNode struct {
+	server   *httptest.Server
+	id       int
+	nodes    map[int]string
+	values   map[string]string
+	requests []*http.Request
+	mux      sync.Mutex
+}
+
+func createLegacyTestNode() *legacyTestNode {
+	legacy := &legacyTestNode{nodes: make(map[int]string), values: make(map[string]string)}
+	legacy.server = httptest.NewServer(http.HandlerFunc(legacy.serve))
+	legacy.id = hash(legacy.server.URL)
+	legacy.nodes[legacy.id] = legacy.server.URL
+	return legacy
+}
+
+func (ln *legacyTestNode) serve(w http.ResponseWriter, r *http.Request) {
+	ln.mux.Lock()
+	defer ln.mux.Unlock()
+	ln.requests = append(ln.requests, r)
+	switch {
+	case r.URL.Path == pingPath:
+	case r.URL.Path == registerPath && r.Method == "PUT":
+		id, err := strconv.Atoi(r.URL.Query().Get(idParam))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ln.nodes[id] = r.URL.Query().Get(addressParam)
+	case r.URL.Path == nodesPath && r.Method == "GET":
+		json.NewEncoder(w).Encode(ln.nodes)
+	case strings.HasPrefix(r.URL.Path, entitiesPath+"/") && r.Method == "PUT":
+		key, _ := url.QueryUnescape(strings.TrimPrefix(r.URL.Path, entitiesPath+"/"))
+		b, _ := ioutil.ReadAll(r.Body)
+		ln.values[key] = string(b)
+	case strings.HasPrefix(r.URL.Path, entitiesPath+"/") && r.Method == "GET":
+		key, _ := url.QueryUnescape(strings.TrimPrefix(r.URL.Path, entitiesPath+"/"))
+		value, found := ln.values[key]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(value))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (ln *legacyTestNode) received() []*http.Request {
+	ln.mux.Lock()
+	defer ln.mux.Unlock()
+	return append([]*http.Request{}, ln.requests...)
+}
+
+func sendLegacyRequest(t *testing.T, verb string, uri string, body string, visited int) (int, string) {
+	request, err := http.NewRequest(verb, uri, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request.Header.Set(visitedHeader, strconv.Itoa(visited))
+	request.Header.Set(hopsHeader, "0")
+	response, err := http.DefaultClient.Do(request)
+	if !assert.NoError(t, err) {
+		return 0, ""
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "", response.Header.Get(protocolHeader))
+	assert.Equal(t, "", response.Header.Get("Content-Encoding"))
+	return response.StatusCode, string(b)
+}
+
+func TestClusterLegacyProtocol(t *testing.T) {
+	legacy := createLegacyTestNode()
+	defer legacy.server.Close()
+	node := createTestNode()
+	node.UseCompression(&CompressionOptions{MinSize: 1})
+	err := node.Connect(legacy.server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy.server.URL, node.registry.Get(legacy.id))
+	assert.Equal(t, node.Address, legacy.nodes[node.ID])
+	assert.Equal(t, legacyProtocol, node.peers.protocol(legacy.id))
+
+	value := strings.Repeat("legacy ", 100)
+	statusCode, _, err := node.putEntryRemote(context.Background(), legacy.server.URL, "old", &Entry{Value: []byte(value), ContentType: "text/plain"}, []int{node.ID}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, value, legacy.values["old"])
+	statusCode, body, err := node.getValueRemote(context.Background(), legacy.server.URL, "old", []int{node.ID}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, value, body)
+	for _, r := range legacy.received() {
+		if !strings.HasPrefix(r.URL.Path, entitiesPath) {
+			continue
+		}
+		assert.Equal(t, "", r.Header.Get(protocolHeader))
+		assert.Equal(t, "", r.Header.Get("Content-Encoding"))
+	}
+
+	statusCode, body = sendLegacyRequest(t, "GET", node.Address+nodesPath, "", legacy.id)
+	assert.Equal(t, http.StatusOK, statusCode)
+	nodes := map[int]string{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &nodes))
+	assert.Equal(t, legacy.server.URL, nodes[legacy.id])
+	statusCode, _ = sendLegacyRequest(t, "PUT", node.Address+entitiesPath+"/new", value, legacy.id)
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode, body = sendLegacyRequest(t, "GET", node.Address+entitiesPath+"/new", "", legacy.id)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, value, body)
+}
+
+func TestNodeMutualTLS(t *testing.T) {
+	certificates := createTestCertificates(t)
+	options := &TLSOptions{
+		CertFile:          certificates.CertFile,
+		KeyFile:           certificates.KeyFile,
+		CAFile:            certificates.CAFile,
+		RequireClientCert: true,
+	}
+	n1 := createTestTLSNode(t, options)
+	n2 := createTestTLSNode(t, options)
+	assert.True(t, strings.HasPrefix(n1.Address, "https://"))
+	err := n2.Connect(n1.Address)
+	assert.NoError(t, err)
+	assert.True(t, n1.registry.Contains(n2.ID))
+
+	statusCode, _, err := n2.pingRemote(n1.Address)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	anonymous := createTestTLSNode(t, &TLSOptions{CAFile: certificates.CAFile})
+	_, _, err = anonymous.pingRemote(n1.Address)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "certificate required")
+	}
+}
+
+func TestNodeMixedTLS(t *testing.T) {
+	certificates := createTestCertificates(t)
+	secure := createTestTLSNode(t, &TLSOptions{
+		CertFile: certificates.CertFile,
+		KeyFile:  certificates.KeyFile,
+		CAFile:   certificates.CAFile,
+	})
+	plain := createTestTLSNode(t, &TLSOptions{CAFile: certificates.CAFile})
+	assert.True(t, strings.HasPrefix(plain.Address, "http://"))
+	err := plain.Connect(secure.Address)
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Address, secure.registry.Get(plain.ID))
+	assert.Equal(t, secure.Address, plain.registry.Get(secure.ID))
+	statusCode, _, err := secure.pingRemote(plain.Address)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestNodeAuthentication(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	uri := node.Address + entitiesPath + "/alice-notes"
+	statusCode := sendTestRequest(t, "PUT", uri, "", "")
+	assert.Equal(t, http.StatusUnauthorized, statusCode)
+	statusCode = sendTestRequest(t, "PUT", uri, "bob-token", "")
+	assert.Equal(t, http.StatusForbidden, statusCode)
+	statusCode = sendTestRequest(t, "PUT", uri, "alice-token", "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode = sendTestRequest(t, "GET", uri, "bob-token", "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode = sendTestRequest(t, "PUT", uri, "", "cluster")
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestNodeClusterSecret(t *testing.T) {
+	n1 := createTestAuthNode("cluster")
+	n2 := createTestAuthNode("cluster")
+	n3 := createTestAuthNode("intruder")
+	statusCode := sendTestRequest(t, "GET", n1.Address + nodesPath, "alice-token", "")
+	assert.Equal(t, http.StatusForbidden, statusCode)
+	err := n2.registerNodeRemote(n1.Address)
+	assert.NoError(t, err)
+	err = n3.registerNodeRemote(n1.Address)
+	assert.Error(t, err)
+	assert.True(t, n1.registry.Contains(n2.ID))
+	assert.False(t, n1.registry.Contains(n3.ID))
+
+	unshared := createTestAuthNode("")
+	err = n2.registerNodeRemote(unshared.Address)
+	assert.Error(t, err)
+	assert.False(t, unshared.registry.Contains(n2.ID))
+	statusCode = sendTestRequest(t, "DELETE", unshared.Address + cachePath + "?" + keyPath + "=a", "alice-token", "")
+	assert.Equal(t, http.StatusForbidden, statusCode)
+}
+
+func TestMetricsAuthorization(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.UseAuthorization(NewPrefixAuthorizer(PrefixRule{Principal: "alice", Prefix: "", Read: true, Write: true}))
+	statusCode := sendTestRequest(t, "GET", node.Address+metricsPath, "bob-token", "")
+	assert.Equal(t, http.StatusForbidden, statusCode)
+	statusCode = sendTestRequest(t, "GET", node.Address+metricsPath, "alice-token", "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode = sendTestRequest(t, "GET", node.Address+metricsPath, "", "cluster")
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestNamespacePutGet(t *testing.T) {
+	cluster := createTestCluster(3)
+	err := cluster[0].CreateNamespace(&Namespace{Name: "team", Replicas: 1, MaxValueBytes: 8})
+	assert.NoError(t, err)
+	for _, node := range cluster {
+		_, found := node.namespaces.get("team")
+		assert.True(t, found)
+	}
+
+	key := namespaceKey("team", "foo")
+	statusCode, _, err := cluster[1].putValueRemote(context.Background(), cluster[1].Address, key, "bar", []int{cluster[1].ID}, redundantCopies)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode, body, err := cluster[2].getValueRemote(context.Background(), cluster[1].Address, key, []int{cluster[2].ID}, redundantCopies)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "bar", body)
+	assert.False(t, cluster[1].store.Contains("foo"))
+
+	statusCode, _, err = cluster[1].getValueRemote(context.Background(), cluster[1].Address, namespaceKey("missing", "foo"), []int{cluster[1].ID}, redundantCopies)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestNamespaceLimits(t *testing.T) {
+	node := createTestNode()
+	err := node.CreateNamespace(&Namespace{Name: "small", Replicas: 1, MaxValueBytes: 4, MaxKeys: 1})
+	assert.NoError(t, err)
+	uri := node.Address + namespacesPath + "/small" + entitiesPath + "/"
+	statusCode := sendTestBody(t, "PUT", uri + "a", "toolong")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, statusCode)
+	statusCode = sendTestBody(t, "PUT", uri + "a", "ok")
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode = sendTestBody(t, "PUT", uri + "a", "fine")
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode = sendTestBody(t, "PUT", uri + "b", "no")
+	assert.Equal(t, http.StatusInsufficientStorage, statusCode)
+}
+
+func TestNamespaceLimitsCompressed(t *testing.T) {
+	node := createTestNode()
+	err := node.CreateNamespace(&Namespace{Name: "packed", Replicas: 1, MaxBytes: 200, Compress: true})
+	assert.NoError(t, err)
+	value := strings.Repeat("a", 2000)
+	statusCode := sendTestBody(t, "PUT", node.Address+namespacesPath+"/packed"+entitiesPath+"/a", value)
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	results := node.BatchPut([]BatchEntry{{Key: namespaceKey("packed", "b"), Value: value}})
+	assert.Equal(t, http.StatusOK, results[0].Status)
+	assert.Equal(t, value, node.Get(namespaceKey("packed", "b")))
+	assert.True(t, node.NamespaceUsage("packed").Bytes < 200)
+}
+
+func TestNamespaceReplicas(t *testing.T) {
+	cluster := createTestCluster(2)
+	err := cluster[0].CreateNamespace(&Namespace{Name: "plain"})
+	assert.NoError(t, err)
+	ns, found := cluster[0].namespaces.get("plain")
+	assert.True(t, found)
+	assert.Equal(t, redundantCopies, ns.Replicas)
+
+	for _, node := range cluster {
+		node.namespaces.merge(&Namespace{Name: "solo", Replicas: 0, Revision: 1})
+	}
+	key := namespaceKey("solo", "foo")
+	owner := cluster[0]
+	if owner.bestMatch(key, []int{}) != owner.ID {
+		owner = cluster[1]
+	}
+	owner.store.PutEntry(key, &Entry{Value: []byte("bar"), Version: 1})
+	owner.updateRandomValue()
+	assert.True(t, owner.store.Contains(key))
+}
+
+func TestNamespaceDrop(t *testing.T) {
+	cluster := createTestCluster(2)
+	err := cluster[0].CreateNamespace(&Namespace{Name: "temp", Replicas: 1})
+	assert.NoError(t, err)
+	key := namespaceKey("temp", "foo")
+	cluster[1].Put(key, "bar")
+	err = cluster[0].DropNamespace("temp")
+	assert.NoError(t, err)
+	assert.False(t, cluster[1].store.Contains(key))
+	assert.Equal(t, 0, len(cluster[1].Namespaces()))
+
+	n := createTestNode()
+	err = n.Connect(cluster[0].Address)
+	assert.NoError(t, err)
+	_, found := n.namespaces.get("temp")
+	assert.False(t, found)
+}
+
+func TestNamespacePeerHeaders(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.UseAuthorization(NewPrefixAuthorizer(PrefixRule{Principal: "alice", Prefix: "", Read: true, Write: true}))
+	err := node.CreateNamespace(&Namespace{Name: "team", Replicas: 1})
+	assert.NoError(t, err)
+	node.Put(namespaceKey("team", "a"), "1")
+	node.Put(chunkKey("upload", 0), "chunk")
+
+	headers := map[string]string{"Authorization": bearerPrefix + "alice-token", visitedHeader: "1"}
+	statusCode, _ := sendTestHeaders(t, "PUT", node.Address + namespacesPath + "/team", `{"replicas": 1, "dropped": true, "revision": 9223372036854775807}`, headers)
+	assert.Equal(t, http.StatusOK, statusCode)
+	ns, found := node.namespaces.get("team")
+	assert.True(t, found)
+	assert.False(t, ns.Dropped)
+	assert.True(t, node.store.Contains(namespaceKey("team", "a")))
+
+	statusCode, _ = sendTestHeaders(t, "GET", node.Address + namespacesPath + "/" + chunkNamespace + entitiesPath + "/upload.0", "", headers)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestEntityRejectsNamespaceSeparator(t *testing.T) {
+	node := createTestNode()
+	err := node.CreateNamespace(&Namespace{Name: "small", Replicas: 1, MaxValueBytes: 4})
+	assert.NoError(t, err)
+	node.Put(chunkKey("upload", 0), "chunk")
+
+	statusCode := sendTestBody(t, "PUT", node.Address+entitiesPath+"/small%1Fa", "toolong")
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	assert.False(t, node.store.Contains(namespaceKey("small", "a")))
+	statusCode, _, _ = getTestBody(t, node.Address+entitiesPath+"/"+chunkNamespace+"%1Fupload.0", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode = sendTestBody(t, "DELETE", node.Address+entitiesPath+"/"+chunkNamespace+"%1Fupload.0", "")
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	assert.True(t, node.store.Contains(chunkKey("upload", 0)))
+	statusCode = sendTestBody(t, "PUT", node.Address+namespacesPath+"/small"+entitiesPath+"/a%1Fb", "ok")
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+}
+
+func TestBatchRejectsNamespaceSeparator(t *testing.T) {
+	node := createTestNode()
+	node.Put(chunkKey("upload", 0), "chunk")
+
+	results := sendTestBatch(t, node.Address+batchPutPath, `{"entries": [{"key": "_chunks\u001fupload.1", "value": "x"}]}`)
+	assert.Equal(t, http.StatusBadRequest, results.Results[0].Status)
+	assert.False(t, node.store.Contains(chunkKey("upload", 1)))
+
+	results = sendTestBatch(t, node.Address+batchGetPath, `{"keys": ["_chunks\u001fupload.0"]}`)
+	assert.Equal(t, http.StatusBadRequest, results.Results[0].Status)
+	assert.Empty(t, results.Results[0].Value)
+}
+
+func sendTestBatch(t *testing.T, uri string, body string) *batchResponse {
+	request, err := http.NewRequest("POST", uri, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	results := &batchResponse{Results: []BatchResult{{}}}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(results))
+	return results
+}
+
+func TestScanRejectsNamespaceSeparator(t *testing.T) {
+	node := createTestNode()
+	node.Put(chunkKey("upload", 0), "chunk")
+	statusCode, _, _ := getTestBody(t, node.Address+entitiesPath+"?"+prefixParam+"=_chunks%1F", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _, _ = getTestBody(t, node.Address+entitiesPath+"?"+cursorParam+"="+encodeCursor(chunkKey("upload", 0)), map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+}
+
+func TestRangeRejectsNamespaceSeparator(t *testing.T) {
+	node := createTestNode()
+	node.Put(chunkKey("upload", 0), "chunk")
+	statusCode, _, _ := getTestBody(t, node.Address+rangePath+"?"+startParam+"=_chunks%1F", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _, _ = getTestBody(t, node.Address+rangePath+"?"+endParam+"=_chunks%1F~", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+}
+
+func TestWatchRejectsNamespaceSeparator(t *testing.T) {
+	node := createTestNode()
+	statusCode, _, _ := getTestBody(t, node.Address+watchPath+"?"+prefixParam+"=_chunks%1F", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _, _ = getTestBody(t, node.Address+watchPath+"?"+keyPath+"=_chunks%1Fupload.0", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+}
+
+func TestDrainPeerHeaders(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.Drain()
+	headers := map[string]string{"Authorization": bearerPrefix + "alice-token", visitedHeader: "1"}
+	statusCode, _ := sendTestHeaders(t, "PUT", node.Address+entitiesPath+"/alice-a", "1", headers)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.False(t, node.store.Contains("alice-a"))
+
+	headers[clusterSecretHeader] = "cluster"
+	statusCode, _ = sendTestHeaders(t, "PUT", node.Address+entitiesPath+"/alice-a", "1", headers)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestStorePeerHeaders(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.UseChunking(&ChunkOptions{ChunkSize: 64, MaxObjectSize: 128})
+	headers := map[string]string{"Authorization": bearerPrefix + "alice-token", visitedHeader: "1"}
+	statusCode, _ := sendTestHeaders(t, "PUT", node.Address+entitiesPath+"/alice-manifest", manifestPrefix+"{}", headers)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = sendTestHeaders(t, "PUT", node.Address+entitiesPath+"/alice-large", strings.Repeat("x", 256), headers)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, statusCode)
+	assert.False(t, node.store.Contains("alice-manifest"))
+	assert.False(t, node.store.Contains("alice-large"))
+}
+
+func TestBatchPeerHeaders(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.UseAuthorization(NewPrefixAuthorizer(PrefixRule{Principal: "alice", Prefix: "", Read: true, Write: true}))
+	node.Put("a", "1")
+
+	headers := map[string]string{"Authorization": bearerPrefix + "bob-token", visitedHeader: "1"}
+	statusCode, _ := sendTestHeaders(t, "POST", node.Address + batchPutPath, `{"entries": [{"key": "b", "value": "2", "version": 1}]}`, headers)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.False(t, node.store.Contains("b"))
+
+	request, err := http.NewRequest("POST", node.Address + batchGetPath, strings.NewReader(`{"keys": ["a"]}`))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer response.Body.Close()
+	batch := &batchResponse{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(batch))
+	if assert.Equal(t, 1, len(batch.Results)) {
+		assert.Equal(t, http.StatusForbidden, batch.Results[0].Status)
+		assert.Empty(t, batch.Results[0].Value)
+	}
+}
+
+func TestCompressedPeerHeaders(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.UseCompression(&CompressionOptions{MinSize: 16})
+	value := strings.Repeat("corduroy ", 64)
+	node.Put("doc", value)
+
+	headers := map[string]string{"Authorization": bearerPrefix + "alice-token", visitedHeader: "1", "Accept-Encoding": "gzip", "Range": "bytes=0-7"}
+	statusCode, body, header := getTestBody(t, node.Address+entitiesPath+"/doc", headers)
+	assert.Equal(t, http.StatusPartialContent, statusCode)
+	assert.Equal(t, "", header.Get("Content-Encoding"))
+	assert.Equal(t, value[:8], body)
+}
+
+func TestScanPeerHeaders(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.UseAuthorization(NewPrefixAuthorizer(PrefixRule{Principal: "alice", Prefix: "", Read: true, Write: true}))
+	node.Put("a", "1")
+	node.Put(namespaceKey("team", "b"), "2")
+
+	headers := map[string]string{"Authorization": bearerPrefix + "bob-token", visitedHeader: "1"}
+	statusCode, body, _ := getTestBody(t, node.Address + entitiesPath + "?" + prefixParam + "=", headers)
+	assert.Equal(t, http.StatusOK, statusCode)
+	result := &ScanResult{}
+	assert.NoError(t, json.Unmarshal([]byte(body), result))
+	assert.Equal(t, 0, len(result.Keys))
+}
+
+func TestRangeAccessLimit(t *testing.T) {
+	node := createTestAuthNode("cluster")
+	node.UseAuthorization(NewPrefixAuthorizer(
+		PrefixRule{Principal: "alice", Prefix: "", Read: true, Write: true},
+		PrefixRule{Principal: "bob", Prefix: "b", Read: true},
+	))
+	for _, key := range []string{"a1", "a2", "b1", "b2"} {
+		node.Put(key, key)
+	}
+
+	for _, peer := range []string{"", "1"} {
+		headers := map[string]string{"Authorization": bearerPrefix + "bob-token", visitedHeader: peer}
+		statusCode, body, _ := getTestBody(t, node.Address + rangePath + "?limit=1", headers)
+		assert.Equal(t, http.StatusOK, statusCode)
+		decoder := json.NewDecoder(strings.NewReader(body))
+		keys := make([]string, 0)
+		for decoder.More() {
+			item := &RangeItem{}
+			assert.NoError(t, decoder.Decode(item))
+			keys = append(keys, item.Key)
+		}
+		assert.Equal(t, []string{"b1"}, keys)
+	}
+}
+
+func sendTestBody(t *testing.T, verb string, uri string, body string) int {
+	request, err := http.NewRequest(verb, uri, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func createTestAuthNode(secret string) *Node {
+	port := getNextTestPort()
+	node := NewNode(port, "/" + strconv.Itoa(port), NewMemoryStore(), NewMemoryRegistry())
+	node.UseAuthentication(NewTokenAuthenticator(map[string]string{"alice": "alice-token", "bob": "bob-token"}))
+	node.UseAuthorization(NewPrefixAuthorizer(
+		PrefixRule{Principal: anyPrincipal, Prefix: "", Read: true},
+		PrefixRule{Principal: "alice", Prefix: "alice-", Read: true, Write: true},
+	))
+	node.UseClusterSecret(secret)
+	node.Start()
+	return node
+}
+
+func sendTestRequest(t *testing.T, verb string, uri string, token string, secret string) int {
+	request, err := http.NewRequest(verb, uri, strings.NewReader("{}"))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", bearerPrefix + token)
+	}
+	if secret != "" {
+		request.Header.Set(clusterSecretHeader, secret)
+	}
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func createTestTLSNode(t *testing.T, options *TLSOptions) *Node {
+	port := getNextTestPort()
+	node := NewNode(port, "/" + strconv.Itoa(port), NewMemoryStore(), NewMemoryRegistry())
+	err := node.UseTLS(options)
+	assert.NoError(t, err)
+	node.Start()
+	return node
+}
+
 func createTestNode() *Node {
 	port := getNextTestPort()
 	store := NewMemoryStore()
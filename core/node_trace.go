@@ -0,0 +1,29 @@
+package corduroy
+
+import (
+	"github.com/emicklei/go-restful"
+	"strconv"
+	"strings"
+)
+
+func (n *Node) UseTracing(exporter SpanExporter) {
+	n.tracer.exporter = exporter
+}
+
+func (n *Node) trace(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	ctx := contextWithTraceparent(request.Request.Context(), request.HeaderParameter(traceparentHeader))
+	route := strings.TrimPrefix(request.SelectedRoutePath(), strings.TrimSuffix(n.service.RootPath(), "/"))
+	ctx, span := n.tracer.start(ctx, request.Request.Method+" "+route)
+	defer span.End()
+	if key := request.PathParameter(keyPath); key != "" {
+		span.SetAttribute(keyField, key)
+	}
+	if hops := request.HeaderParameter(hopsHeader); hops != "" {
+		span.SetAttribute(hopsField, hops)
+	}
+
+	request.Request = request.Request.WithContext(ctx)
+	response.AddHeader(traceIDHeader, span.TraceID)
+	chain.ProcessFilter(request, response)
+	span.SetAttribute("status", strconv.Itoa(response.StatusCode()))
+}
@@ -0,0 +1,332 @@
+package corduroy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const watchPath = "/watch"
+const sinceParam = "since"
+const eventStreamMime = "text/event-stream"
+const lastEventIDHeader = "Last-Event-ID"
+const watchKeepAliveSeconds = 15
+
+func encodeWatchCursor(cursor map[int]uint64) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeWatchCursor(s string) (map[int]uint64, error) {
+	cursor := make(map[int]uint64)
+	if s == "" {
+		return cursor, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watch cursor '%s'", s)
+	}
+	err = json.Unmarshal(b, &cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watch cursor '%s'", s)
+	}
+	return cursor, nil
+}
+
+func (n *Node) notify(kind string, key string, entry *Entry) {
+	if n.bestMatch(key, []int{}) == n.ID {
+		n.watches.publish(kind, key, entry)
+	}
+}
+
+func (n *Node) Watch(ctx context.Context, prefix string) <-chan Event {
+	events, err := n.watchCluster(ctx, prefix, map[int]uint64{})
+	if err != nil {
+		n.logger.Warn("unable to watch prefix", F("prefix", prefix), F(errorField, err))
+		closed := make(chan Event)
+		close(closed)
+		return closed
+	}
+	return events
+}
+
+func (n *Node) watchCluster(ctx context.Context, prefix string, cursor map[int]uint64) (<-chan Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan Event, watchBufferSize)
+	var wg sync.WaitGroup
+
+	since, resume := cursor[n.ID]
+	local, err := n.watches.subscribe(prefix, since, resume)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		n.watches.unsubscribe(local)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		forwardEvents(ctx, local.events, out, nil)
+	}()
+
+	for id, address := range n.registry.GetAll() {
+		if id == n.ID {
+			continue
+		}
+		since, resume := cursor[id]
+		remote, err := n.watchRemote(ctx, address, prefix, since, resume)
+		if err == ErrRevisionCompacted {
+			cancel()
+			return nil, err
+		}
+		if err != nil {
+			n.logger.Warn("unable to watch node", F(peerField, address), F(errorField, err))
+			closed := make(chan Event)
+			close(closed)
+			remote = closed
+		}
+		wg.Add(1)
+		go func(id int, since uint64, resume bool, remote <-chan Event) {
+			defer wg.Done()
+			err := n.followRemote(ctx, id, prefix, since, resume, remote, out)
+			if err != nil {
+				n.logger.Warn("stopped watch after losing events", F("peer_id", id), F(errorField, err))
+				cancel()
+			}
+		}(id, since, resume, remote)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(out)
+	}()
+	return out, nil
+}
+
+func forwardEvents(ctx context.Context, source <-chan Event, out chan<- Event, last *uint64) {
+	for {
+		select {
+		case event, ok := <-source:
+			if !ok {
+				return
+			}
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+			if last != nil {
+				*last = event.Revision
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *Node) followRemote(ctx context.Context, id int, prefix string, since uint64, resume bool, events <-chan Event, out chan<- Event) error {
+	attempt := 0
+	for {
+		last := since
+		forwardEvents(ctx, events, out, &last)
+		if last != since {
+			since, resume, attempt = last, true, 0
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		address := n.registry.Get(id)
+		if address == "" {
+			n.logger.Debug("stopped watching departed node", F("peer_id", id))
+			return nil
+		}
+		select {
+		case <-time.After(backoff(attempt, time.Millisecond*connectInitialBackoffMilliseconds, time.Second*connectMaxBackoffSeconds)):
+		case <-ctx.Done():
+			return nil
+		}
+		attempt++
+
+		var err error
+		events, err = n.watchRemote(ctx, address, prefix, since, resume)
+		if err == ErrRevisionCompacted {
+			return err
+		}
+		if err != nil {
+			n.logger.Debug("unable to rewatch node", F(peerField, address), F(errorField, err))
+			closed := make(chan Event)
+			close(closed)
+			events = closed
+		}
+	}
+}
+
+func (n *Node) watchRemote(ctx context.Context, address string, prefix string, since uint64, resume bool) (<-chan Event, error) {
+	query := url.Values{}
+	query.Set(prefixParam, prefix)
+	if resume {
+		query.Set(sinceParam, strconv.FormatUint(since, 10))
+	}
+	uri := address + watchPath + "?" + query.Encode()
+	n.logger.Debug("sending watch request", F(peerField, uri))
+
+	response, err := n.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusGone {
+		response.Body.Close()
+		return nil, ErrRevisionCompacted
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("unexpected status code '%d'", response.StatusCode)
+	}
+
+	events := make(chan Event, watchBufferSize)
+	go func() {
+		defer close(events)
+		defer response.Body.Close()
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			event := Event{}
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event) != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (n *Node) watchValues(request *restful.Request, response *restful.Response) {
+	n.serveWatch(request, response, defaultNamespace)
+}
+
+func (n *Node) watchNamespacedValues(request *restful.Request, response *restful.Response) {
+	ns, found := n.lookupNamespace(request, response)
+	if !found {
+		return
+	}
+	n.serveWatch(request, response, ns.Name)
+}
+
+func (n *Node) serveWatch(request *restful.Request, response *restful.Response, ns string) {
+	ctx := request.Request.Context()
+	var events <-chan Event
+	var cursor map[int]uint64
+	peer := n.isPeer(request)
+	if peer {
+		s := request.QueryParameter(sinceParam)
+		since, err := strconv.ParseUint(s, 10, 64)
+		if s != "" && err != nil {
+			response.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		w, err := n.watches.subscribe(request.QueryParameter(prefixParam), since, s != "")
+		if err != nil {
+			response.WriteError(http.StatusGone, err)
+			return
+		}
+		go func() {
+			<-ctx.Done()
+			n.watches.unsubscribe(w)
+		}()
+		events = w.events
+	} else {
+		key := request.QueryParameter(keyPath)
+		prefix := request.QueryParameter(prefixParam)
+		if key != "" {
+			prefix = key
+		}
+		if err := validateKey(prefix); err != nil {
+			response.WriteErrorString(http.StatusBadRequest, err.Error())
+			return
+		}
+		c := request.QueryParameter(cursorParam)
+		if c == "" {
+			c = request.HeaderParameter(lastEventIDHeader)
+		}
+		var err error
+		cursor, err = decodeWatchCursor(c)
+		if err != nil {
+			response.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		events, err = n.watchCluster(ctx, namespaceKey(ns, prefix), cursor)
+		if err == ErrRevisionCompacted {
+			response.WriteError(http.StatusGone, err)
+			return
+		}
+		if err != nil {
+			response.WriteError(http.StatusBadGateway, err)
+			return
+		}
+	}
+
+	response.AddHeader("Content-Type", eventStreamMime)
+	response.AddHeader("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	flusher, _ := response.ResponseWriter.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(time.Second * watchKeepAliveSeconds)
+	defer keepAlive.Stop()
+	key := request.QueryParameter(keyPath)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			id := strconv.FormatUint(event.Revision, 10)
+			if !peer {
+				name, k := splitNamespaceKey(event.Key)
+				cursor[event.Node] = event.Revision
+				if name != ns || (key != "" && k != key) || !n.allowed(request, displayKey(ns, k), false) {
+					continue
+				}
+				event.Key = k
+				id = encodeWatchCursor(cursor)
+			}
+			b, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(response, "id: %s\nevent: %s\ndata: %s\n\n", id, event.Type, b)
+		case <-keepAlive.C:
+			fmt.Fprint(response, ": keepalive\n\n")
+		case <-ctx.Done():
+			return
+		case <-n.done:
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package corduroy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const wirePath = "/wire"
+const wireUpgrade = "corduroy-wire"
+const wireVersionsHeader = "X-Corduroy-Wire-Versions"
+const wireVersionHeader = "X-Corduroy-Wire-Version"
+const wireRetrySeconds = 60
+
+var wireVersions = []int{1}
+
+var errWireUnsupported = errors.New("peer does not support the wire protocol")
+
+type wireTransport struct {
+	node        *Node
+	enabled     bool
+	clients     map[string]*wireConn
+	unsupported map[string]time.Time
+	serving     map[net.Conn]bool
+	active      sync.WaitGroup
+	mux         sync.Mutex
+}
+
+type wireConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	version  int
+	next     uint32
+	pending  map[uint32]chan *wireFrame
+	err      error
+	writeMux sync.Mutex
+	mux      sync.Mutex
+}
+
+type wireResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newWireTransport(node *Node) *wireTransport {
+	return &wireTransport{
+		node:        node,
+		clients:     make(map[string]*wireConn),
+		unsupported: make(map[string]time.Time),
+		serving:     make(map[net.Conn]bool),
+	}
+}
+
+func (n *Node) UseWireProtocol(enabled bool) {
+	n.wire.mux.Lock()
+	defer n.wire.mux.Unlock()
+	n.wire.enabled = enabled
+}
+
+func (wt *wireTransport) isEnabled() bool {
+	wt.mux.Lock()
+	defer wt.mux.Unlock()
+	return wt.enabled
+}
+
+func (wt *wireTransport) exchange(ctx context.Context, address string, verb string, uri string, body string, header http.Header) (int, string, http.Header, error) {
+	wc, err := wt.get(ctx, address)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	frame, err := wc.roundTrip(ctx, encodeWireRequest(verb, u.RequestURI(), header, body), wt.node.peerOptions.Timeout)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	statusCode, responseHeader, b, err := decodeWireResponse(frame.payload)
+	if err != nil {
+		wc.close(err)
+		return 0, "", nil, err
+	}
+	return statusCode, string(b), responseHeader, nil
+}
+
+func (wt *wireTransport) get(ctx context.Context, address string) (*wireConn, error) {
+	wt.mux.Lock()
+	if wc, found := wt.clients[address]; found {
+		wt.mux.Unlock()
+		return wc, nil
+	}
+	if until, found := wt.unsupported[address]; found && time.Now().Before(until) {
+		wt.mux.Unlock()
+		return nil, errWireUnsupported
+	}
+	wt.mux.Unlock()
+
+	wc, err := wt.dial(ctx, address)
+	wt.mux.Lock()
+	defer wt.mux.Unlock()
+	if err == errWireUnsupported {
+		wt.unsupported[address] = time.Now().Add(time.Second * wireRetrySeconds)
+		wt.node.logger.Info("peer does not support the wire protocol, using http", F(peerField, address))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing, found := wt.clients[address]; found {
+		wc.conn.Close()
+		return existing, nil
+	}
+	delete(wt.unsupported, address)
+	wt.clients[address] = wc
+	go wt.receive(address, wc)
+	wt.node.logger.Debug("opened wire connection", F(peerField, address), F("version", wc.version))
+	return wc, nil
+}
+
+func (wt *wireTransport) dial(ctx context.Context, address string) (*wireConn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	options := wt.node.peerOptions
+	dialer := &net.Dialer{Timeout: options.DialTimeout, KeepAlive: time.Second * 30}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(options.Timeout))
+	if u.Scheme == "https" {
+		config := &tls.Config{}
+		if transport, ok := wt.node.client.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+			config = transport.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, config)
+	}
+
+	request, err := http.NewRequest("GET", address+wirePath, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	request.Header = buildPeerHeader([]int{wt.node.ID}, 0)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", wireUpgrade)
+	request.Header.Set(wireVersionsHeader, formatVersions(wireVersions))
+	if secret := wt.node.secret(); secret != "" {
+		request.Header.Set(clusterSecretHeader, secret)
+	}
+	err = request.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, errWireUnsupported
+	}
+	version, err := strconv.Atoi(response.Header.Get(wireVersionHeader))
+	if err != nil || !containsID(wireVersions, version) {
+		conn.Close()
+		return nil, fmt.Errorf("peer chose unsupported wire version '%s'", response.Header.Get(wireVersionHeader))
+	}
+	conn.SetDeadline(time.Time{})
+	return &wireConn{conn: conn, reader: reader, version: version, pending: make(map[uint32]chan *wireFrame)}, nil
+}
+
+func (wt *wireTransport) receive(address string, wc *wireConn) {
+	for {
+		frame, err := readWireFrame(wc.reader)
+		if err != nil {
+			wc.close(err)
+			break
+		}
+		if frame.kind != wireResponseFrame {
+			wc.close(ErrWireMalformed)
+			break
+		}
+		wc.deliver(frame)
+	}
+
+	wt.mux.Lock()
+	if wt.clients[address] == wc {
+		delete(wt.clients, address)
+	}
+	wt.mux.Unlock()
+	wt.node.logger.Debug("closed wire connection", F(peerField, address), F(errorField, wc.err))
+}
+
+func (wc *wireConn) roundTrip(ctx context.Context, payload []byte, timeout time.Duration) (*wireFrame, error) {
+	responses := make(chan *wireFrame, 1)
+	wc.mux.Lock()
+	if wc.err != nil {
+		wc.mux.Unlock()
+		return nil, wc.err
+	}
+	wc.next++
+	stream := wc.next
+	wc.pending[stream] = responses
+	wc.mux.Unlock()
+
+	wc.writeMux.Lock()
+	wc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	err := writeWireFrame(wc.conn, &wireFrame{stream: stream, kind: wireRequestFrame, payload: payload})
+	wc.writeMux.Unlock()
+	if err != nil {
+		wc.close(err)
+		return nil, err
+	}
+
+	select {
+	case frame, ok := <-responses:
+		if !ok {
+			return nil, wc.failure()
+		}
+		return frame, nil
+	case <-ctx.Done():
+		wc.mux.Lock()
+		delete(wc.pending, stream)
+		wc.mux.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (wc *wireConn) deliver(frame *wireFrame) {
+	wc.mux.Lock()
+	defer wc.mux.Unlock()
+	if responses, found := wc.pending[frame.stream]; found {
+		delete(wc.pending, frame.stream)
+		responses <- frame
+	}
+}
+
+func (wc *wireConn) failure() error {
+	wc.mux.Lock()
+	defer wc.mux.Unlock()
+	return wc.err
+}
+
+func (wc *wireConn) close(err error) {
+	wc.mux.Lock()
+	defer wc.mux.Unlock()
+	if wc.err != nil {
+		return
+	}
+	wc.err = err
+	if wc.err == nil {
+		wc.err = errors.New("wire connection closed")
+	}
+	wc.conn.Close()
+	for stream, responses := range wc.pending {
+		delete(wc.pending, stream)
+		close(responses)
+	}
+}
+
+func (wt *wireTransport) serve(conn net.Conn, reader *bufio.Reader, state *tls.ConnectionState, host string) {
+	wt.mux.Lock()
+	wt.serving[conn] = true
+	wt.mux.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var writeMux sync.Mutex
+	for {
+		frame, err := readWireFrame(reader)
+		if err != nil || frame.kind != wireRequestFrame {
+			break
+		}
+		wt.active.Add(1)
+		go func(frame *wireFrame) {
+			defer wt.active.Done()
+			payload := wt.handle(ctx, frame.payload, conn.RemoteAddr().String(), state, host)
+			writeMux.Lock()
+			defer writeMux.Unlock()
+			err := writeWireFrame(conn, &wireFrame{stream: frame.stream, kind: wireResponseFrame, payload: payload})
+			if err != nil {
+				conn.Close()
+			}
+		}(frame)
+	}
+
+	cancel()
+	conn.Close()
+	wt.mux.Lock()
+	delete(wt.serving, conn)
+	wt.mux.Unlock()
+}
+
+func (wt *wireTransport) handle(ctx context.Context, payload []byte, remote string, state *tls.ConnectionState, host string) []byte {
+	method, path, header, body, err := decodeWireRequest(payload)
+	if err != nil {
+		return encodeWireResponse(http.StatusBadRequest, http.Header{}, []byte(err.Error()))
+	}
+	request, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		return encodeWireResponse(http.StatusBadRequest, http.Header{}, []byte(err.Error()))
+	}
+	request = request.WithContext(ctx)
+	request.Header = header
+	request.Host = host
+	request.RequestURI = path
+	request.RemoteAddr = remote
+	request.TLS = state
+
+	writer := &wireResponseWriter{header: http.Header{}}
+	handler := wt.node.server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	handler.ServeHTTP(writer, request)
+	if writer.statusCode == 0 {
+		writer.statusCode = http.StatusOK
+	}
+	wt.node.metrics.wireRequests.add(1)
+	return encodeWireResponse(writer.statusCode, writer.header, writer.body.Bytes())
+}
+
+func (wt *wireTransport) close(ctx context.Context) {
+	wait := make(chan struct{})
+	go func() {
+		wt.active.Wait()
+		close(wait)
+	}()
+	select {
+	case <-wait:
+	case <-ctx.Done():
+	}
+
+	wt.mux.Lock()
+	defer wt.mux.Unlock()
+	for conn := range wt.serving {
+		conn.Close()
+	}
+	for _, wc := range wt.clients {
+		wc.close(errors.New("node stopped"))
+	}
+}
+
+func (wt *wireTransport) connections() int {
+	wt.mux.Lock()
+	defer wt.mux.Unlock()
+	return len(wt.clients) + len(wt.serving)
+}
+
+func (rw *wireResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *wireResponseWriter) WriteHeader(statusCode int) {
+	if rw.statusCode == 0 {
+		rw.statusCode = statusCode
+	}
+}
+
+func (rw *wireResponseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.body.Write(b)
+}
+
+func (n *Node) upgradeWire(request *restful.Request, response *restful.Response) {
+	if !n.wire.isEnabled() {
+		response.WriteErrorString(http.StatusNotFound, "wire protocol disabled")
+		return
+	}
+	version := negotiateWireVersion(request.HeaderParameter(wireVersionsHeader))
+	if version == 0 {
+		response.AddHeader(wireVersionsHeader, formatVersions(wireVersions))
+		response.WriteErrorString(http.StatusUpgradeRequired, "no common wire protocol version")
+		return
+	}
+	hijacker, ok := response.ResponseWriter.(http.Hijacker)
+	if !ok {
+		response.WriteErrorString(http.StatusInternalServerError, "connection cannot be upgraded")
+		return
+	}
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		n.logger.Warn("unable to upgrade connection", F(errorField, err))
+		return
+	}
+	fmt.Fprintf(buffered, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n%s: %d\r\n\r\n", wireUpgrade, wireVersionHeader, version)
+	err = buffered.Flush()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	n.logger.Debug("accepted wire connection", F("remote", conn.RemoteAddr().String()), F("version", version))
+	go n.wire.serve(conn, buffered.Reader, request.Request.TLS, request.Request.Host)
+}
+
+func negotiateWireVersion(offered string) int {
+	best := 0
+	for _, v := range strings.Split(offered, ",") {
+		version, err := strconv.Atoi(strings.TrimSpace(v))
+		if err == nil && version > best && containsID(wireVersions, version) {
+			best = version
+		}
+	}
+	return best
+}
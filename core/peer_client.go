@@ -0,0 +1,244 @@
+package corduroy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPeerTimeout = time.Second * 5
+const defaultPeerBulkTimeout = time.Second * 60
+const defaultPeerDialTimeout = time.Second * 2
+const defaultPeerMaxIdleConns = 32
+const defaultPeerIdleConnTimeout = time.Second * 90
+const defaultPeerRetries = 2
+const defaultPeerRetryBackoff = time.Millisecond * 50
+const defaultPeerMaxRetryBackoff = time.Second
+const defaultBreakerThreshold = 5
+const defaultBreakerCooldown = time.Second * 10
+
+var ErrPeerUnavailable = errors.New("peer unavailable, circuit open")
+
+type PeerClientOptions struct {
+	Timeout          time.Duration
+	BulkTimeout      time.Duration
+	DialTimeout      time.Duration
+	MaxIdleConns     int
+	IdleConnTimeout  time.Duration
+	Retries          int
+	RetryBackoff     time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func (o *PeerClientOptions) withDefaults() *PeerClientOptions {
+	options := *o
+	if options.Timeout <= 0 {
+		options.Timeout = defaultPeerTimeout
+	}
+	if options.BulkTimeout <= 0 {
+		options.BulkTimeout = defaultPeerBulkTimeout
+	}
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = defaultPeerDialTimeout
+	}
+	if options.MaxIdleConns <= 0 {
+		options.MaxIdleConns = defaultPeerMaxIdleConns
+	}
+	if options.IdleConnTimeout <= 0 {
+		options.IdleConnTimeout = defaultPeerIdleConnTimeout
+	}
+	if options.Retries < 0 {
+		options.Retries = 0
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = defaultPeerRetryBackoff
+	}
+	if options.BreakerThreshold <= 0 {
+		options.BreakerThreshold = defaultBreakerThreshold
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = defaultBreakerCooldown
+	}
+	return &options
+}
+
+func defaultPeerClientOptions() *PeerClientOptions {
+	return (&PeerClientOptions{Retries: defaultPeerRetries}).withDefaults()
+}
+
+func newPeerClient(options *PeerClientOptions) *http.Client {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	configureTransport(transport, options)
+	return &http.Client{Transport: transport}
+}
+
+func configureTransport(transport *http.Transport, options *PeerClientOptions) {
+	dialer := &net.Dialer{Timeout: options.DialTimeout, KeepAlive: time.Second * 30}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = options.DialTimeout
+	transport.MaxIdleConns = options.MaxIdleConns * 4
+	transport.MaxIdleConnsPerHost = options.MaxIdleConns
+	transport.IdleConnTimeout = options.IdleConnTimeout
+}
+
+func (n *Node) UsePeerClient(options *PeerClientOptions) {
+	n.peerOptions = options.withDefaults()
+	configureTransport(n.client.Transport.(*http.Transport), n.peerOptions)
+	n.peers.configure(n.peerOptions.BreakerThreshold, n.peerOptions.BreakerCooldown)
+}
+
+func (n *Node) bulkContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, n.peerOptions.BulkTimeout)
+}
+
+func (n *Node) peerID(uri string) (int, bool) {
+	for id, address := range n.registry.GetAll() {
+		if strings.HasPrefix(uri, address) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (n *Node) available(id int) bool {
+	return id == n.ID || n.peers.available(id)
+}
+
+func (n *Node) open(ctx context.Context, uri string) (*http.Response, error) {
+	return n.openWith(ctx, uri, buildPeerHeader([]int{n.ID}, 0))
+}
+
+func (n *Node) openWith(ctx context.Context, uri string, header http.Header) (*http.Response, error) {
+	id, known := n.peerID(uri)
+	if known && !n.available(id) {
+		return nil, ErrPeerUnavailable
+	}
+	if secret := n.secret(); secret != "" {
+		header.Set(clusterSecretHeader, secret)
+	}
+	setProtocol(header, n.protocolFor(id, known))
+	_, span := n.tracer.start(ctx, "peer GET")
+	defer span.End()
+	span.SetAttribute(peerField, uri)
+	header.Set(traceparentHeader, span.traceparent())
+
+	response, err := open(ctx, n.client, "GET", uri, header)
+	span.SetError(err)
+	if err != nil {
+		n.observePeer(uri, 0, err)
+		return nil, err
+	}
+	n.observePeer(uri, response.StatusCode, nil)
+	if known {
+		n.observeProtocol(id, response.StatusCode, response.Header)
+	}
+	span.SetAttribute("status", strconv.Itoa(response.StatusCode))
+	return response, nil
+}
+
+func idempotent(verb string, header http.Header) bool {
+	switch verb {
+	case "GET", "HEAD", "DELETE":
+		return true
+	case "PUT":
+		return header.Get(versionHeader) != "" && header.Get("If-Match") == "" && header.Get("If-None-Match") == ""
+	}
+	return false
+}
+
+func retryable(statusCode int, err error) bool {
+	if err != nil {
+		return err != ErrPeerUnavailable
+	}
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable || statusCode == http.StatusGatewayTimeout
+}
+
+type peerContact struct {
+	lastSuccess time.Time
+	lastFailure time.Time
+	failures    int
+	openUntil   time.Time
+	protocol    int
+}
+
+type peerTracker struct {
+	contacts  map[int]*peerContact
+	threshold int
+	cooldown  time.Duration
+	mux       sync.Mutex
+}
+
+func newPeerTracker() *peerTracker {
+	return &peerTracker{contacts: make(map[int]*peerContact), threshold: defaultBreakerThreshold, cooldown: defaultBreakerCooldown}
+}
+
+func (pt *peerTracker) configure(threshold int, cooldown time.Duration) {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	pt.threshold = threshold
+	pt.cooldown = cooldown
+}
+
+func (pt *peerTracker) record(id int, success bool) {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	contact, found := pt.contacts[id]
+	if !found {
+		contact = &peerContact{}
+		pt.contacts[id] = contact
+	}
+	now := time.Now()
+	if success {
+		contact.lastSuccess = now
+		contact.failures = 0
+		contact.openUntil = time.Time{}
+		return
+	}
+	contact.lastFailure = now
+	contact.failures++
+	if contact.failures >= pt.threshold {
+		contact.openUntil = now.Add(pt.cooldown)
+	}
+}
+
+func (pt *peerTracker) available(id int) bool {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	contact, found := pt.contacts[id]
+	return !found || !time.Now().Before(contact.openUntil)
+}
+
+func (pt *peerTracker) setProtocol(id int, version int) {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	contact, found := pt.contacts[id]
+	if !found {
+		contact = &peerContact{}
+		pt.contacts[id] = contact
+	}
+	contact.protocol = version
+}
+
+func (pt *peerTracker) protocol(id int) int {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	if contact, found := pt.contacts[id]; found {
+		return contact.protocol
+	}
+	return 0
+}
+
+func (pt *peerTracker) get(id int) peerContact {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	if contact, found := pt.contacts[id]; found {
+		return *contact
+	}
+	return peerContact{}
+}
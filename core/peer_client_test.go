@@ -0,0 +1,101 @@
+package corduroy
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeerTrackerBreaker(t *testing.T) {
+	tracker := newPeerTracker()
+	tracker.configure(2, time.Millisecond*50)
+	assert.True(t, tracker.available(7))
+
+	tracker.record(7, false)
+	assert.True(t, tracker.available(7))
+	tracker.record(7, false)
+	assert.False(t, tracker.available(7))
+
+	time.Sleep(time.Millisecond * 60)
+	assert.True(t, tracker.available(7))
+	tracker.record(7, false)
+	assert.False(t, tracker.available(7))
+
+	time.Sleep(time.Millisecond * 60)
+	tracker.record(7, true)
+	tracker.record(7, false)
+	assert.True(t, tracker.available(7))
+}
+
+func TestPeerClientRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	port := getNextTestPort()
+	node := NewNode(port, "/"+strconv.Itoa(port), NewMemoryStore(), NewMemoryRegistry())
+	node.UsePeerClient(&PeerClientOptions{Retries: 2, RetryBackoff: time.Millisecond})
+	statusCode, body, err := node.send(context.Background(), "GET", server.URL, "", buildPeerHeader([]int{node.ID}, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	statusCode, _, err = node.send(context.Background(), "POST", server.URL, "", buildPeerHeader([]int{node.ID}, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestPeerClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	port := getNextTestPort()
+	node := NewNode(port, "/"+strconv.Itoa(port), NewMemoryStore(), NewMemoryRegistry())
+	node.UsePeerClient(&PeerClientOptions{Timeout: time.Millisecond * 50})
+	start := time.Now()
+	_, _, err := node.send(context.Background(), "POST", server.URL, "", buildPeerHeader([]int{node.ID}, 0))
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestClusterSkipsOpenCircuit(t *testing.T) {
+	cluster := createTestCluster(3)
+	node := cluster[0]
+	node.UsePeerClient(&PeerClientOptions{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+	key := "circuit"
+	owner := node.bestMatch(key, []int{node.ID})
+	for _, n := range cluster {
+		if n.ID == owner {
+			n.Stop()
+		}
+	}
+
+	_, _, err := node.pingRemote(node.registry.Get(owner))
+	assert.Error(t, err)
+	_, _, err = node.pingRemote(node.registry.Get(owner))
+	assert.Equal(t, ErrPeerUnavailable, err)
+	assert.Equal(t, owner, node.bestMatch(key, []int{node.ID}))
+	assert.NotEqual(t, owner, node.availableMatch(key, []int{node.ID}))
+	assert.True(t, node.Status().Peers[0].CircuitOpen || node.Status().Peers[1].CircuitOpen)
+}
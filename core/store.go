@@ -2,11 +2,14 @@ package corduroy
 
 import (
 	"strings"
+	"time"
 )
 
 type Store interface {
 	Put(key string, value string)
+	PutEntry(key string, entry *Entry)
 	Get(key string) string
+	GetEntry(key string) *Entry
 	GetRandomKey() string
 	GetKeys(first int, count int) []string
 	Delete(key string)
@@ -14,9 +17,50 @@ type Store interface {
 	Size() int
 }
 
+type RangeStore interface {
+	Store
+	Range(start string, end string, limit int) []string
+	Prefix(prefix string) []string
+}
+
+type Entry struct {
+	Value       []byte
+	Expiry      time.Time
+	Version     uint64
+	ContentType string
+	Meta        map[string]string
+	Codec       string
+}
+
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.Expiry.IsZero() && !now.Before(e.Expiry)
+}
+
+func nextVersion(current uint64) uint64 {
+	now := uint64(time.Now().UnixNano())
+	if now > current {
+		return now
+	}
+	return current + 1
+}
+
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
 func StoreFromShorthand(s string) Store {
 	if strings.EqualFold(strings.ToLower(s), "memory") {
 		return NewMemoryStore()
 	}
+	if strings.EqualFold(strings.ToLower(s), "ordered") {
+		return NewOrderedStore()
+	}
 	return nil
 }
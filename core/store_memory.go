@@ -6,37 +6,59 @@ import (
 )
 
 type MemoryStore struct {
-	values       map[string]string
+	values       map[string]*Entry
 	index        []string
 	reverseIndex map[string]int
-	indexMux     sync.Mutex
+	indexMux     sync.RWMutex
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		values:       make(map[string]string),
+		values:       make(map[string]*Entry),
 		index:        make([]string, 0),
 		reverseIndex: make(map[string]int),
 	}
 }
 
 func (ms *MemoryStore) Put(key string, value string) {
+	ms.PutEntry(key, &Entry{Value: []byte(value)})
+}
+
+func (ms *MemoryStore) PutEntry(key string, entry *Entry) {
+	e := *entry
 	ms.indexMux.Lock()
-	ms.values[key] = value
-	ms.reverseIndex[key] = len(ms.index)
-	ms.index = append(ms.index, key)
+	if _, found := ms.values[key]; !found {
+		ms.reverseIndex[key] = len(ms.index)
+		ms.index = append(ms.index, key)
+	}
+	ms.values[key] = &e
 	ms.indexMux.Unlock()
 }
 
 func (ms *MemoryStore) Get(key string) string {
-	return ms.values[key]
+	entry := ms.GetEntry(key)
+	if entry == nil {
+		return ""
+	}
+	return string(entry.Value)
+}
+
+func (ms *MemoryStore) GetEntry(key string) *Entry {
+	ms.indexMux.RLock()
+	defer ms.indexMux.RUnlock()
+	entry, found := ms.values[key]
+	if !found {
+		return nil
+	}
+	e := *entry
+	return &e
 }
 
 func (ms *MemoryStore) GetRandomKey() string {
-	ms.indexMux.Lock()
-	r := rand.Int() % ms.Size()
+	ms.indexMux.RLock()
+	r := rand.Int() % len(ms.index)
 	key := ms.index[r]
-	ms.indexMux.Unlock()
+	ms.indexMux.RUnlock()
 	return key
 }
 
@@ -46,19 +68,25 @@ func (ms *MemoryStore) GetKeys(first int, length int) []string {
 		f = 0
 	}
 
-	l := first + length
-	s := ms.Size()
-	ms.indexMux.Lock()
+	l := f + length
+	ms.indexMux.RLock()
+	s := len(ms.index)
 	if l > s {
 		l = s
 	}
+	if f > l {
+		f = l
+	}
 
-	keys := ms.index[f:l]
-	ms.indexMux.Unlock()
+	keys := make([]string, l-f)
+	copy(keys, ms.index[f:l])
+	ms.indexMux.RUnlock()
 	return keys
 }
 
 func (ms *MemoryStore) Contains(key string) bool {
+	ms.indexMux.RLock()
+	defer ms.indexMux.RUnlock()
 	if _, found := ms.values[key]; found {
 		return true
 	}
@@ -67,13 +95,20 @@ func (ms *MemoryStore) Contains(key string) bool {
 
 func (ms *MemoryStore) Delete(key string) {
 	ms.indexMux.Lock()
-	delete(ms.values, key)
-	n := ms.reverseIndex[key]
-	ms.index = append(ms.index[:n], ms.index[n+1:]...)
-	delete(ms.reverseIndex, key)
+	if _, found := ms.values[key]; found {
+		delete(ms.values, key)
+		n := ms.reverseIndex[key]
+		ms.index = append(ms.index[:n], ms.index[n+1:]...)
+		delete(ms.reverseIndex, key)
+		for i := n; i < len(ms.index); i++ {
+			ms.reverseIndex[ms.index[i]] = i
+		}
+	}
 	ms.indexMux.Unlock()
 }
 
 func (ms *MemoryStore) Size() int {
+	ms.indexMux.RLock()
+	defer ms.indexMux.RUnlock()
 	return len(ms.index)
 }
@@ -0,0 +1,189 @@
+package corduroy
+
+import (
+	"math/rand"
+	"sync"
+)
+
+const skipListMaxLevel = 24
+const skipListProbability = 0.25
+
+type skipListNode struct {
+	key   string
+	entry *Entry
+	next  []*skipListNode
+}
+
+type OrderedStore struct {
+	head  *skipListNode
+	level int
+	size  int
+	mux   sync.RWMutex
+}
+
+func NewOrderedStore() *OrderedStore {
+	return &OrderedStore{
+		head:  &skipListNode{next: make([]*skipListNode, skipListMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomSkipListLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListProbability {
+		level++
+	}
+	return level
+}
+
+func (sl *OrderedStore) findPredecessors(key string) []*skipListNode {
+	update := make([]*skipListNode, skipListMaxLevel)
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	return update
+}
+
+func (sl *OrderedStore) seek(key string) *skipListNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+	}
+	return x.next[0]
+}
+
+func (sl *OrderedStore) Put(key string, value string) {
+	sl.PutEntry(key, &Entry{Value: []byte(value)})
+}
+
+func (sl *OrderedStore) PutEntry(key string, entry *Entry) {
+	e := *entry
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	update := sl.findPredecessors(key)
+	if x := update[0].next[0]; x != nil && x.key == key {
+		x.entry = &e
+		return
+	}
+
+	level := randomSkipListLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.head
+		}
+		sl.level = level
+	}
+	x := &skipListNode{key: key, entry: &e, next: make([]*skipListNode, level)}
+	for i := 0; i < level; i++ {
+		x.next[i] = update[i].next[i]
+		update[i].next[i] = x
+	}
+	sl.size++
+}
+
+func (sl *OrderedStore) Get(key string) string {
+	entry := sl.GetEntry(key)
+	if entry == nil {
+		return ""
+	}
+	return string(entry.Value)
+}
+
+func (sl *OrderedStore) GetEntry(key string) *Entry {
+	sl.mux.RLock()
+	defer sl.mux.RUnlock()
+	x := sl.seek(key)
+	if x == nil || x.key != key {
+		return nil
+	}
+	e := *x.entry
+	return &e
+}
+
+func (sl *OrderedStore) GetRandomKey() string {
+	sl.mux.RLock()
+	defer sl.mux.RUnlock()
+	if sl.size == 0 {
+		return ""
+	}
+	x := sl.head.next[0]
+	for r := rand.Int() % sl.size; r > 0; r-- {
+		x = x.next[0]
+	}
+	return x.key
+}
+
+func (sl *OrderedStore) GetKeys(first int, length int) []string {
+	if first < 0 {
+		first = 0
+	}
+	sl.mux.RLock()
+	defer sl.mux.RUnlock()
+	keys := make([]string, 0)
+	i := 0
+	for x := sl.head.next[0]; x != nil && len(keys) < length; x = x.next[0] {
+		if i >= first {
+			keys = append(keys, x.key)
+		}
+		i++
+	}
+	return keys
+}
+
+func (sl *OrderedStore) Range(start string, end string, limit int) []string {
+	sl.mux.RLock()
+	defer sl.mux.RUnlock()
+	keys := make([]string, 0)
+	for x := sl.seek(start); x != nil; x = x.next[0] {
+		if end != "" && x.key >= end {
+			break
+		}
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, x.key)
+	}
+	return keys
+}
+
+func (sl *OrderedStore) Prefix(prefix string) []string {
+	return sl.Range(prefix, prefixEnd(prefix), 0)
+}
+
+func (sl *OrderedStore) Delete(key string) {
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	update := sl.findPredecessors(key)
+	x := update[0].next[0]
+	if x == nil || x.key != key {
+		return
+	}
+	for i := 0; i < len(x.next); i++ {
+		if update[i].next[i] == x {
+			update[i].next[i] = x.next[i]
+		}
+	}
+	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
+		sl.level--
+	}
+	sl.size--
+}
+
+func (sl *OrderedStore) Contains(key string) bool {
+	sl.mux.RLock()
+	defer sl.mux.RUnlock()
+	x := sl.seek(key)
+	return x != nil && x.key == key
+}
+
+func (sl *OrderedStore) Size() int {
+	sl.mux.RLock()
+	defer sl.mux.RUnlock()
+	return sl.size
+}
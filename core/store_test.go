@@ -2,8 +2,11 @@ package corduroy
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestMemoryStorePutGet(t *testing.T) {
@@ -33,6 +36,9 @@ func TestMemoryStoreSize(t *testing.T) {
 	value2 := "solo"
 	store.Put(key2, value2)
 	assert.Equal(t, 2, store.Size())
+	store.Put(key1, value2)
+	assert.Equal(t, 2, store.Size())
+	assert.Equal(t, value2, store.Get(key1))
 }
 
 func TestMemoryStorePutGetKeys(t *testing.T) {
@@ -52,3 +58,64 @@ func TestMemoryStorePutGetKeys(t *testing.T) {
 	keys = store.GetKeys(0, 3)
 	assert.Equal(t, 2, len(keys))
 }
+
+func TestMemoryStoreDelete(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put("john", "lennon")
+	store.Put("paul", "mccartney")
+	store.Put("george", "harrison")
+	store.Delete("paul")
+	store.Delete("ringo")
+	assert.Equal(t, 2, store.Size())
+	assert.False(t, store.Contains("paul"))
+	store.Delete("george")
+	keys := store.GetKeys(0, 3)
+	assert.Equal(t, []string{"john"}, keys)
+}
+
+func TestMemoryStoreEntryExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	expiry := time.Now().Add(time.Minute)
+	store.PutEntry("session", &Entry{Value: []byte("abc"), Expiry: expiry})
+	entry := store.GetEntry("session")
+	assert.Equal(t, []byte("abc"), entry.Value)
+	assert.True(t, expiry.Equal(entry.Expiry))
+	assert.False(t, entry.Expired(time.Now()))
+	assert.True(t, entry.Expired(expiry))
+	assert.Nil(t, store.GetEntry("missing"))
+}
+
+func TestOrderedStoreRange(t *testing.T) {
+	store := NewOrderedStore()
+	for _, key := range []string{"delta", "alpha", "charlie", "bravo", "echo", "alphabet"} {
+		store.Put(key, key)
+	}
+	store.Put("alpha", "first")
+	assert.Equal(t, 6, store.Size())
+	assert.Equal(t, "first", store.Get("alpha"))
+	assert.Equal(t, []string{"bravo", "charlie"}, store.Range("b", "d", 0))
+	assert.Equal(t, []string{"bravo"}, store.Range("b", "", 1))
+	assert.Equal(t, []string{"alpha", "alphabet"}, store.Prefix("alpha"))
+	assert.Equal(t, []string{"charlie", "delta"}, store.GetKeys(3, 2))
+
+	store.Delete("charlie")
+	store.Delete("missing")
+	assert.False(t, store.Contains("charlie"))
+	assert.Equal(t, []string{"bravo", "delta"}, store.Range("b", "e", 0))
+	assert.Equal(t, 5, store.Size())
+	assert.True(t, store.Contains(store.GetRandomKey()))
+}
+
+func TestOrderedStoreManyKeys(t *testing.T) {
+	store := NewOrderedStore()
+	for i := 999; i >= 0; i-- {
+		store.Put(fmt.Sprintf("key-%04d", i), "v")
+	}
+	for i := 0; i < 1000; i += 2 {
+		store.Delete(fmt.Sprintf("key-%04d", i))
+	}
+	keys := store.Prefix("key-")
+	assert.Equal(t, 500, len(keys))
+	assert.True(t, sort.StringsAreSorted(keys))
+	assert.Equal(t, "key-0001", keys[0])
+}
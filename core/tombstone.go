@@ -0,0 +1,46 @@
+package corduroy
+
+import (
+	"sync"
+	"time"
+)
+
+type tombstone struct {
+	version uint64
+	until   time.Time
+}
+
+type tombstones struct {
+	keys      map[string]tombstone
+	retention time.Duration
+	mux       sync.Mutex
+}
+
+func newTombstones(retention time.Duration) *tombstones {
+	return &tombstones{keys: make(map[string]tombstone), retention: retention}
+}
+
+func (ts *tombstones) record(key string, version uint64, now time.Time) {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	if current, found := ts.keys[key]; found && current.version > version {
+		version = current.version
+	}
+	ts.keys[key] = tombstone{version: version, until: now.Add(ts.retention)}
+}
+
+func (ts *tombstones) version(key string) uint64 {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	return ts.keys[key].version
+}
+
+func (ts *tombstones) prune(now time.Time) {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	for key, t := range ts.keys {
+		if !now.Before(t.until) {
+			delete(ts.keys, key)
+		}
+	}
+}
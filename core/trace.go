@@ -0,0 +1,148 @@
+package corduroy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const traceparentHeader = "traceparent"
+const traceIDHeader = "X-Corduroy-Trace-Id"
+const traceVersion = "00"
+const traceSampled = "01"
+
+type spanKey struct{}
+
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+type Span struct {
+	TraceID    string            `json:"traceId"`
+	SpanID     string            `json:"spanId"`
+	ParentID   string            `json:"parentId,omitempty"`
+	Name       string            `json:"name"`
+	Node       int               `json:"node"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	exporter SpanExporter
+	mux      sync.Mutex
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.Error = err.Error()
+}
+
+func (s *Span) End() {
+	s.mux.Lock()
+	s.Duration = time.Since(s.Start)
+	s.mux.Unlock()
+	if s.exporter != nil {
+		s.exporter.Export(s)
+	}
+}
+
+func (s *Span) traceparent() string {
+	return traceVersion + "-" + s.TraceID + "-" + s.SpanID + "-" + traceSampled
+}
+
+type SpanExporter interface {
+	Export(span *Span) error
+}
+
+type FileSpanExporter struct {
+	file *os.File
+	mux  sync.Mutex
+}
+
+func NewFileSpanExporter(path string) (*FileSpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSpanExporter{file: f}, nil
+}
+
+func (fe *FileSpanExporter) Export(span *Span) error {
+	span.mux.Lock()
+	b, err := json.Marshal(span)
+	span.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	fe.mux.Lock()
+	defer fe.mux.Unlock()
+	_, err = fe.file.Write(append(b, '\n'))
+	return err
+}
+
+func (fe *FileSpanExporter) Close() error {
+	fe.mux.Lock()
+	defer fe.mux.Unlock()
+	return fe.file.Close()
+}
+
+type tracer struct {
+	node     int
+	exporter SpanExporter
+}
+
+func (t *tracer) start(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{SpanID: randomHex(8), Name: name, Node: t.node, Start: time.Now(), exporter: t.exporter}
+	switch parent := ctx.Value(spanKey{}).(type) {
+	case *Span:
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	case spanContext:
+		span.TraceID = parent.traceID
+		span.ParentID = parent.spanID
+	default:
+		span.TraceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func contextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || parts[0] == "ff" || !isTraceHex(parts[1], 32) || !isTraceHex(parts[2], 16) {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, spanContext{traceID: parts[1], spanID: parts[2]})
+}
+
+func isTraceHex(s string, length int) bool {
+	if len(s) != length || strings.Trim(s, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,65 @@
+package corduroy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceparentPropagation(t *testing.T) {
+	tr := &tracer{node: 1}
+	ctx := contextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tr.start(ctx, "GET /entities/{key}")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentID)
+	assert.Equal(t, 16, len(span.SpanID))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanID+"-01", span.traceparent())
+
+	for _, invalid := range []string{"", "garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"} {
+		_, span = tr.start(contextWithTraceparent(context.Background(), invalid), "root")
+		assert.Equal(t, "", span.ParentID)
+		assert.Equal(t, 32, len(span.TraceID))
+	}
+}
+
+func TestFileSpanExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "corduroy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spans.log")
+
+	exporter, err := NewFileSpanExporter(path)
+	assert.NoError(t, err)
+	tr := &tracer{node: 7, exporter: exporter}
+	ctx, parent := tr.start(context.Background(), "parent")
+	_, child := tr.start(ctx, "child")
+	child.SetAttribute(keyField, "foo")
+	child.SetError(errors.New("boom"))
+	child.End()
+	parent.End()
+	assert.NoError(t, exporter.Close())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	spans := make([]*Span, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		span := &Span{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), span))
+		spans = append(spans, span)
+	}
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, parent.SpanID, spans[0].ParentID)
+	assert.Equal(t, parent.TraceID, spans[0].TraceID)
+	assert.Equal(t, "foo", spans[0].Attributes[keyField])
+	assert.Equal(t, "boom", spans[0].Error)
+	assert.Equal(t, 7, spans[1].Node)
+}
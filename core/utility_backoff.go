@@ -0,0 +1,22 @@
+package corduroy
+
+import (
+	"math/rand"
+	"time"
+)
+
+func backoff(attempt int, initial time.Duration, max time.Duration) time.Duration {
+	wait := initial
+	for i := 0; i < attempt && wait < max; i++ {
+		wait = wait * 2
+	}
+	if wait > max {
+		wait = max
+	}
+
+	half := int64(wait / 2)
+	if half <= 0 {
+		return wait
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
@@ -0,0 +1,67 @@
+package corduroy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type flightGroup struct {
+	calls map[string]*flightCall
+	mux   sync.Mutex
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+func (fg *flightGroup) do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, bool, error) {
+	fg.mux.Lock()
+	if call, found := fg.calls[key]; found {
+		fg.mux.Unlock()
+		select {
+		case <-call.done:
+			return call.value, true, call.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+	call := &flightCall{done: make(chan struct{})}
+	fg.calls[key] = call
+	fg.mux.Unlock()
+
+	defer func() {
+		fg.mux.Lock()
+		delete(fg.calls, key)
+		fg.mux.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn()
+	return call.value, false, call.err
+}
+
+type detachedContext struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
@@ -1,15 +1,22 @@
 package corduroy
 
 import (
+	"context"
 	"net"
 	"os"
 	"strconv"
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
+const dnsScheme = "dns"
+const defaultSeedScheme = "http"
+
 func buildLocalUri(port int) string {
 	host := getLocalhost()
 	return buildUri(host, port)
@@ -51,14 +58,46 @@ func getLocalAddresses() ([]*net.IP, error) {
 	return addresses, nil
 }
 
-func send(verb string, uri string, body string, visited []int, hops int) (int, string, error) {
-	b1 := []byte(body)
-	buff := bytes.NewBuffer(b1[:])
-	request, err := http.NewRequest(verb, uri, buff)
-	if err != nil {
-		return 0, "", err
+func expandSeeds(seeds []string, logger Logger) []string {
+	uris := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		seed = strings.TrimSpace(seed)
+		if seed == "" {
+			continue
+		}
+
+		u, err := url.Parse(seed)
+		if err != nil || !strings.HasPrefix(u.Scheme, dnsScheme) {
+			uris = append(uris, seed)
+			continue
+		}
+
+		scheme := defaultSeedScheme
+		if strings.HasPrefix(u.Scheme, dnsScheme+"+") {
+			scheme = strings.TrimPrefix(u.Scheme, dnsScheme+"+")
+		}
+
+		hosts, err := net.LookupHost(u.Hostname())
+		if err != nil {
+			logger.Warn("unable to resolve seed", F(peerField, seed), F(errorField, err))
+			continue
+		}
+
+		port := u.Port()
+		for _, host := range hosts {
+			address := host
+			if port != "" {
+				address = net.JoinHostPort(host, port)
+			} else if strings.Contains(host, ":") {
+				address = "[" + host + "]"
+			}
+			uris = append(uris, scheme+"://"+address+u.EscapedPath())
+		}
 	}
+	return uris
+}
 
+func buildPeerHeader(visited []int, hops int) http.Header {
 	v := ""
 	for _, id := range visited {
 		if v != "" {
@@ -67,22 +106,166 @@ func send(verb string, uri string, body string, visited []int, hops int) (int, s
 		v = v + strconv.Itoa(id)
 	}
 
-	request.Header.Set("Content-Type", "application/json; charset=utf-8")
-	request.Header.Set(visitedHeader, v)
-	request.Header.Set(hopsHeader, strconv.Itoa(hops))
-	client := &http.Client{}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set(visitedHeader, v)
+	header.Set(hopsHeader, strconv.Itoa(hops))
+	return header
+}
+
+func exchange(ctx context.Context, client *http.Client, verb string, uri string, body string, header http.Header) (int, string, http.Header, error) {
+	b1 := []byte(body)
+	buff := bytes.NewBuffer(b1[:])
+	request, err := http.NewRequest(verb, uri, buff)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	request = request.WithContext(ctx)
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
 	response, err := client.Do(request)
 	if err != nil {
-		return 0, "", err
+		return 0, "", nil, err
 	}
 
 	defer response.Body.Close()
 	b2, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return 0, "", err
+		return 0, "", nil, err
 	}
 
-	return response.StatusCode, string(b2), nil
+	return response.StatusCode, string(b2), response.Header, nil
+}
+
+func open(ctx context.Context, client *http.Client, verb string, uri string, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest(verb, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	return client.Do(request)
+}
+
+func copyHeaders(to http.Header, from http.Header, names ...string) {
+	for _, name := range names {
+		if v := from.Get(name); v != "" {
+			to.Set(name, v)
+		}
+	}
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func formatETag(version uint64) string {
+	return "\"" + strconv.FormatUint(version, 10) + "\""
+}
+
+func acceptsEncoding(header string, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.TrimSpace(params[0])
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		if accepted {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRange(header string, size int64) (int64, int64, bool, error) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, size, false, nil
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, size, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, false, errors.New("range not satisfiable")
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	end := size
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, size, false, nil
+		}
+		if e+1 < end {
+			end = e + 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errors.New("range not satisfiable")
+	}
+	return start, end, true, nil
+}
+
+func parseETag(tag string) (uint64, error) {
+	return strconv.ParseUint(strings.Trim(strings.TrimPrefix(tag, "W/"), "\""), 10, 64)
+}
+
+func matchesETag(condition string, version uint64, exists bool) bool {
+	if !exists {
+		return false
+	}
+	for _, tag := range strings.Split(condition, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == formatETag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseVersion(headers *http.Header) (uint64, bool, error) {
+	v := headers.Get(versionHeader)
+	if v == "" {
+		return 0, false, nil
+	}
+	version, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
 }
 
 func parseVisited(headers *http.Header) ([]int, error) {
@@ -100,6 +283,38 @@ func parseVisited(headers *http.Header) ([]int, error) {
 	return visited, nil
 }
 
+func parseExpiry(headers *http.Header) (time.Time, error) {
+	if e := headers.Get(expiresHeader); e != "" {
+		return time.Parse(time.RFC3339Nano, e)
+	}
+
+	return parseTTL(headers.Get(ttlHeader))
+}
+
+func parseTTL(t string) (time.Time, error) {
+	if t == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.Atoi(t); err == nil {
+		if seconds <= 0 {
+			return time.Time{}, errors.New("ttl must be positive")
+		}
+		return time.Now().Add(time.Second * time.Duration(seconds)), nil
+	}
+	ttl, err := time.ParseDuration(t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ttl <= 0 {
+		return time.Time{}, errors.New("ttl must be positive")
+	}
+	return time.Now().Add(ttl), nil
+}
+
+func formatExpiry(expiry time.Time) string {
+	return expiry.UTC().Format(time.RFC3339Nano)
+}
+
 func parseHops(headers *http.Header) (int, error) {
 	hops, err := strconv.Atoi(headers.Get(hopsHeader))
 	if err != nil {
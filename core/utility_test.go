@@ -1,8 +1,23 @@
 package corduroy
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func newTestObject(payload string) *testObject {
@@ -22,6 +37,73 @@ func getNextTestPort() int {
 	return testPort
 }
 
+type testCertificates struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+func createTestCertificates(t *testing.T) *testCertificates {
+	dir, err := ioutil.TempDir("", "corduroy")
+	assert.NoError(t, err)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "corduroy test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(caBytes)
+	assert.NoError(t, err)
+
+	certificates := &testCertificates{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+	writeTestPEM(t, certificates.CAFile, "CERTIFICATE", caBytes)
+	writeTestLeafCertificate(t, certificates, ca, caKey, "corduroy test node")
+	return certificates
+}
+
+func writeTestLeafCertificate(t *testing.T, certificates *testCertificates, ca *x509.Certificate, caKey *ecdsa.PrivateKey, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{getLocalhost(), "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	writeTestPEM(t, certificates.CertFile, "CERTIFICATE", certBytes)
+	writeTestPEM(t, certificates.KeyFile, "EC PRIVATE KEY", keyBytes)
+}
+
+func writeTestPEM(t *testing.T, path string, kind string, b []byte) {
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	err = pem.Encode(f, &pem.Block{Type: kind, Bytes: b})
+	assert.NoError(t, err)
+}
+
 func TestGetLocalAddresses(t *testing.T) {
 	a, err := getLocalAddresses()
 	assert.NoError(t, err)
@@ -38,3 +120,143 @@ func TestHash(t *testing.T) {
 	i3 := hash(s3)
 	assert.NotEqual(t, i, i3)
 }
+
+func TestExpandSeeds(t *testing.T) {
+	seeds := expandSeeds([]string{"http://localhost:8080/", "dns://localhost:8081/ring"}, defaultLogger())
+	assert.Equal(t, "http://localhost:8080/", seeds[0])
+	assert.Contains(t, seeds, "http://127.0.0.1:8081/ring")
+}
+
+func TestBackoff(t *testing.T) {
+	initial := time.Millisecond * 100
+	max := time.Second
+	assert.True(t, backoff(0, initial, max) <= initial)
+	assert.True(t, backoff(3, initial, max) >= initial*4)
+	assert.True(t, backoff(20, initial, max) <= max)
+}
+
+func TestFlightGroup(t *testing.T) {
+	group := newFlightGroup()
+	release := make(chan struct{})
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		<-release
+		return calls, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 5)
+	shared := make([]bool, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], shared[i], _ = group.do(context.Background(), "key", fn)
+		}(i)
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+
+	sharers := 0
+	for i := range results {
+		assert.Equal(t, 1, results[i])
+		if shared[i] {
+			sharers++
+		}
+	}
+	assert.Equal(t, 4, sharers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	block := make(chan struct{})
+	go group.do(context.Background(), "other", func() (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	time.Sleep(time.Millisecond * 10)
+	_, _, err := group.do(ctx, "other", fn)
+	assert.Equal(t, context.Canceled, err)
+	close(block)
+
+	_, ok := detach(ctx).Deadline()
+	assert.False(t, ok)
+	assert.NoError(t, detach(ctx).Err())
+}
+
+func TestParseRange(t *testing.T) {
+	start, end, partial, err := parseRange("", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 10}, []int64{start, end})
+	assert.False(t, partial)
+
+	start, end, partial, err = parseRange("bytes=2-4", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 5}, []int64{start, end})
+	assert.True(t, partial)
+
+	start, end, _, err = parseRange("bytes=7-", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{7, 10}, []int64{start, end})
+
+	start, end, _, err = parseRange("bytes=-3", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{7, 10}, []int64{start, end})
+
+	start, end, _, err = parseRange("bytes=5-100", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5, 10}, []int64{start, end})
+
+	_, _, partial, err = parseRange("bytes=0-1,4-5", 10)
+	assert.NoError(t, err)
+	assert.False(t, partial)
+
+	_, _, _, err = parseRange("bytes=10-", 10)
+	assert.Error(t, err)
+	_, _, _, err = parseRange("bytes=-0", 10)
+	assert.Error(t, err)
+}
+
+func TestCertificateReloader(t *testing.T) {
+	certificates := createTestCertificates(t)
+	reloader, err := newCertificateReloader(certificates.CertFile, certificates.KeyFile)
+	assert.NoError(t, err)
+	first := reloader.current()
+	assert.NotNil(t, first)
+	assert.Equal(t, first, reloader.current())
+
+	other := createTestCertificates(t)
+	b, err := ioutil.ReadFile(other.CertFile)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certificates.CertFile, b, 0600))
+	b, err = ioutil.ReadFile(other.KeyFile)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certificates.KeyFile, b, 0600))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certificates.CertFile, later, later))
+	assert.NotEqual(t, first.Certificate[0], reloader.current().Certificate[0])
+}
+
+func TestEncodeValue(t *testing.T) {
+	item := batchItem{Key: "k", Value: []byte("\xff\x00bytes")}
+	b, err := json.Marshal(item)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"encoding":"base64"`)
+	decoded := batchItem{}
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, item.Value, decoded.Value)
+
+	b, err = json.Marshal(Change{Key: "k", Value: []byte("text")})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "encoding")
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	assert.True(t, acceptsEncoding("gzip", "gzip"))
+	assert.True(t, acceptsEncoding("deflate, GZIP;q=0.5", "gzip"))
+	assert.True(t, acceptsEncoding("*", "gzip"))
+	assert.False(t, acceptsEncoding("", "gzip"))
+	assert.False(t, acceptsEncoding("identity", "gzip"))
+	assert.False(t, acceptsEncoding("gzip;q=0", "gzip"))
+}
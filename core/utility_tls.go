@@ -0,0 +1,147 @@
+package corduroy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+type TLSOptions struct {
+	CertFile          string
+	KeyFile           string
+	CAFile            string
+	RequireClientCert bool
+}
+
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	modTime     time.Time
+	logger      Logger
+	mux         sync.Mutex
+}
+
+func newCertificateReloader(certFile string, keyFile string) (*certificateReloader, error) {
+	cr := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   defaultLogger(),
+	}
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certificateReloader) reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	if cr.certificate != nil && !modTime.After(cr.modTime) {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	if cr.certificate != nil {
+		cr.logger.Info("reloaded certificate", F("path", cr.certFile))
+	}
+	cr.certificate = &certificate
+	cr.modTime = modTime
+	return nil
+}
+
+func (cr *certificateReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+func (cr *certificateReloader) current() *tls.Certificate {
+	err := cr.reload()
+	if err != nil {
+		cr.logger.Warn("unable to reload certificate, keeping previous", F("path", cr.certFile), F(errorField, err))
+	}
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	return cr.certificate
+}
+
+func (cr *certificateReloader) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.current(), nil
+}
+
+func (cr *certificateReloader) getClientCertificate(request *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return cr.current(), nil
+}
+
+func loadCertificatePool(caFile string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no certificates found in '" + caFile + "'")
+	}
+	return pool, nil
+}
+
+func buildTLSConfigs(options *TLSOptions, logger Logger) (*tls.Config, *tls.Config, error) {
+	var server *tls.Config
+	client := &tls.Config{}
+
+	var pool *x509.CertPool
+	if options.CAFile != "" {
+		p, err := loadCertificatePool(options.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool = p
+		client.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		reloader, err := newCertificateReloader(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		reloader.logger = logger
+
+		server = &tls.Config{GetCertificate: reloader.getCertificate}
+		client.GetClientCertificate = reloader.getClientCertificate
+		if options.RequireClientCert {
+			if pool == nil {
+				return nil, nil, errors.New("client certificates require a trusted ca bundle")
+			}
+			server.ClientCAs = pool
+			server.ClientAuth = tls.RequireAndVerifyClientCert
+		} else if pool != nil {
+			server.ClientCAs = pool
+			server.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return server, client, nil
+}
@@ -0,0 +1,115 @@
+package corduroy
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+const PutEvent = "put"
+const DeleteEvent = "delete"
+const ExpireEvent = "expire"
+
+const watchHistorySize = 4096
+const watchBufferSize = 256
+
+var ErrRevisionCompacted = errors.New("requested revision is no longer available")
+
+type Event struct {
+	Node     int    `json:"node"`
+	Revision uint64 `json:"revision"`
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Value    []byte `json:"-"`
+	Size     int64  `json:"size,omitempty"`
+	Version  uint64 `json:"version,omitempty"`
+}
+
+type watcher struct {
+	prefix string
+	events chan Event
+}
+
+type watchHub struct {
+	node     int
+	revision uint64
+	history  []Event
+	watchers map[*watcher]bool
+	mux      sync.Mutex
+}
+
+func newWatchHub(node int) *watchHub {
+	return &watchHub{
+		node:     node,
+		history:  make([]Event, 0),
+		watchers: make(map[*watcher]bool),
+	}
+}
+
+func (wh *watchHub) publish(kind string, key string, entry *Entry) {
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	wh.revision++
+	event := Event{Node: wh.node, Revision: wh.revision, Type: kind, Key: key}
+	if entry != nil {
+		event.Value, event.Size = listedValue(entry.Value)
+		event.Version = entry.Version
+	}
+
+	wh.history = append(wh.history, event)
+	if len(wh.history) > watchHistorySize {
+		wh.history = wh.history[len(wh.history)-watchHistorySize:]
+	}
+
+	for w := range wh.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			delete(wh.watchers, w)
+			close(w.events)
+		}
+	}
+}
+
+func (wh *watchHub) subscribe(prefix string, since uint64, resume bool) (*watcher, error) {
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	if !resume || since > wh.revision {
+		since = wh.revision
+	}
+	if since < wh.revision && len(wh.history) > 0 && wh.history[0].Revision > since+1 {
+		return nil, ErrRevisionCompacted
+	}
+
+	backlog := make([]Event, 0)
+	for _, event := range wh.history {
+		if event.Revision > since && strings.HasPrefix(event.Key, prefix) {
+			backlog = append(backlog, event)
+		}
+	}
+
+	w := &watcher{prefix: prefix, events: make(chan Event, watchBufferSize+len(backlog))}
+	for _, event := range backlog {
+		w.events <- event
+	}
+	wh.watchers[w] = true
+	return w, nil
+}
+
+func (wh *watchHub) unsubscribe(w *watcher) {
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	if wh.watchers[w] {
+		delete(wh.watchers, w)
+		close(w.events)
+	}
+}
+
+func (wh *watchHub) currentRevision() uint64 {
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	return wh.revision
+}
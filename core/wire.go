@@ -0,0 +1,301 @@
+package corduroy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const wireFrameHeaderSize = 9
+const maxWireFrameSize = 64 << 20
+
+const wireRequestFrame byte = 1
+const wireResponseFrame byte = 2
+
+const wireHasVisited byte = 1
+const wireHasHops byte = 2
+const wireHasVersion byte = 4
+
+var ErrWireFrameTooLarge = errors.New("wire frame too large")
+var ErrWireMalformed = errors.New("malformed wire message")
+
+type wireFrame struct {
+	stream  uint32
+	kind    byte
+	payload []byte
+}
+
+func writeWireFrame(w io.Writer, frame *wireFrame) error {
+	if len(frame.payload) > maxWireFrameSize-wireFrameHeaderSize {
+		return ErrWireFrameTooLarge
+	}
+	b := make([]byte, wireFrameHeaderSize, wireFrameHeaderSize+len(frame.payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(frame.payload)+wireFrameHeaderSize-4))
+	binary.BigEndian.PutUint32(b[4:8], frame.stream)
+	b[8] = frame.kind
+	_, err := w.Write(append(b, frame.payload...))
+	return err
+}
+
+func readWireFrame(r io.Reader) (*wireFrame, error) {
+	header := make([]byte, wireFrameHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < wireFrameHeaderSize-4 {
+		return nil, ErrWireMalformed
+	}
+	if length > maxWireFrameSize-4 {
+		return nil, ErrWireFrameTooLarge
+	}
+	frame := &wireFrame{
+		stream:  binary.BigEndian.Uint32(header[4:8]),
+		kind:    header[8],
+		payload: make([]byte, length-(wireFrameHeaderSize-4)),
+	}
+	_, err = io.ReadFull(r, frame.payload)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+type wireEncoder struct {
+	b []byte
+}
+
+func (e *wireEncoder) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	e.b = append(e.b, buf[:n]...)
+}
+
+func (e *wireEncoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	e.b = append(e.b, buf[:n]...)
+}
+
+func (e *wireEncoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *wireEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *wireEncoder) header(header http.Header) {
+	count := 0
+	for _, values := range header {
+		count += len(values)
+	}
+	e.uvarint(uint64(count))
+	for name, values := range header {
+		for _, value := range values {
+			e.string(name)
+			e.string(value)
+		}
+	}
+}
+
+type wireDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *wireDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = ErrWireMalformed
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *wireDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = ErrWireMalformed
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *wireDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) == 0 {
+		d.err = ErrWireMalformed
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *wireDecoder) bytes() []byte {
+	length := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if length > uint64(len(d.b)) {
+		d.err = ErrWireMalformed
+		return nil
+	}
+	v := d.b[:length]
+	d.b = d.b[length:]
+	return v
+}
+
+func (d *wireDecoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *wireDecoder) header() http.Header {
+	count := d.uvarint()
+	header := http.Header{}
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		name := d.string()
+		header[name] = append(header[name], d.string())
+	}
+	return header
+}
+
+func encodeWireRequest(method string, path string, header http.Header, body string) []byte {
+	rest := http.Header{}
+	var flags byte
+	var visited []uint64
+	var hops int64
+	var version uint64
+	for name, values := range header {
+		switch {
+		case name == visitedHeader && len(values) == 1:
+			if ids, ok := parseWireVisited(values[0]); ok {
+				visited = ids
+				flags |= wireHasVisited
+				continue
+			}
+		case name == hopsHeader && len(values) == 1:
+			if h, err := strconv.ParseInt(values[0], 10, 64); err == nil {
+				hops = h
+				flags |= wireHasHops
+				continue
+			}
+		case name == versionHeader && len(values) == 1:
+			if v, err := strconv.ParseUint(values[0], 10, 64); err == nil {
+				version = v
+				flags |= wireHasVersion
+				continue
+			}
+		}
+		rest[name] = values
+	}
+
+	e := &wireEncoder{b: make([]byte, 0, len(method)+len(path)+len(body)+64)}
+	e.string(method)
+	e.string(path)
+	e.b = append(e.b, flags)
+	if flags&wireHasVisited != 0 {
+		e.uvarint(uint64(len(visited)))
+		for _, id := range visited {
+			e.uvarint(id)
+		}
+	}
+	if flags&wireHasHops != 0 {
+		e.varint(hops)
+	}
+	if flags&wireHasVersion != 0 {
+		e.uvarint(version)
+	}
+	e.header(rest)
+	e.string(body)
+	return e.b
+}
+
+func decodeWireRequest(payload []byte) (string, string, http.Header, []byte, error) {
+	d := &wireDecoder{b: payload}
+	method := d.string()
+	path := d.string()
+	flags := d.byte()
+	ids := make([]string, 0)
+	if flags&wireHasVisited != 0 {
+		count := d.uvarint()
+		for i := uint64(0); i < count && d.err == nil; i++ {
+			ids = append(ids, strconv.FormatUint(d.uvarint(), 10))
+		}
+	}
+	var hops int64
+	if flags&wireHasHops != 0 {
+		hops = d.varint()
+	}
+	var version uint64
+	if flags&wireHasVersion != 0 {
+		version = d.uvarint()
+	}
+	header := d.header()
+	body := d.bytes()
+	if d.err != nil {
+		return "", "", nil, nil, d.err
+	}
+
+	if flags&wireHasVisited != 0 {
+		header.Set(visitedHeader, strings.Join(ids, ","))
+	}
+	if flags&wireHasHops != 0 {
+		header.Set(hopsHeader, strconv.FormatInt(hops, 10))
+	}
+	if flags&wireHasVersion != 0 {
+		header.Set(versionHeader, strconv.FormatUint(version, 10))
+	}
+	return method, path, header, body, nil
+}
+
+func encodeWireResponse(statusCode int, header http.Header, body []byte) []byte {
+	e := &wireEncoder{b: make([]byte, 0, len(body)+32)}
+	e.uvarint(uint64(statusCode))
+	e.header(header)
+	e.bytes(body)
+	return e.b
+}
+
+func decodeWireResponse(payload []byte) (int, http.Header, []byte, error) {
+	d := &wireDecoder{b: payload}
+	statusCode := int(d.uvarint())
+	header := d.header()
+	body := d.bytes()
+	if d.err != nil {
+		return 0, nil, nil, d.err
+	}
+	return statusCode, header, body, nil
+}
+
+func parseWireVisited(v string) ([]uint64, bool) {
+	ids := make([]uint64, 0)
+	if v == "" {
+		return ids, true
+	}
+	for _, s := range strings.Split(v, ",") {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
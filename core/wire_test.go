@@ -0,0 +1,60 @@
+package corduroy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestWireFrames(t *testing.T) {
+	b := &bytes.Buffer{}
+	err := writeWireFrame(b, &wireFrame{stream: 7, kind: wireRequestFrame, payload: []byte("hello")})
+	assert.NoError(t, err)
+	err = writeWireFrame(b, &wireFrame{stream: 8, kind: wireResponseFrame})
+	assert.NoError(t, err)
+
+	frame, err := readWireFrame(b)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(7), frame.stream)
+	assert.Equal(t, wireRequestFrame, frame.kind)
+	assert.Equal(t, "hello", string(frame.payload))
+	frame, err = readWireFrame(b)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(8), frame.stream)
+	assert.Empty(t, frame.payload)
+
+	_, err = readWireFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1, 1}))
+	assert.Equal(t, ErrWireFrameTooLarge, err)
+}
+
+func TestWireMessages(t *testing.T) {
+	header := buildPeerHeader([]int{42, 4294967295}, 3)
+	header.Set(versionHeader, "1792422644603616084")
+	header.Set(expiresHeader, "2030-01-01T00:00:00Z")
+	payload := encodeWireRequest("PUT", "/8080/entities/k?x=1", header, "value")
+
+	method, path, decoded, body, err := decodeWireRequest(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "PUT", method)
+	assert.Equal(t, "/8080/entities/k?x=1", path)
+	assert.Equal(t, "value", string(body))
+	assert.Equal(t, header, decoded)
+
+	_, _, _, _, err = decodeWireRequest(payload[:len(payload)-2])
+	assert.Equal(t, ErrWireMalformed, err)
+
+	payload = encodeWireResponse(http.StatusCreated, http.Header{"Etag": []string{"\"1\""}}, []byte("ok"))
+	statusCode, responseHeader, body, err := decodeWireResponse(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, statusCode)
+	assert.Equal(t, "\"1\"", responseHeader.Get("ETag"))
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestNegotiateWireVersion(t *testing.T) {
+	assert.Equal(t, 1, negotiateWireVersion("1"))
+	assert.Equal(t, 1, negotiateWireVersion("3, 2,1"))
+	assert.Equal(t, 0, negotiateWireVersion("2"))
+	assert.Equal(t, 0, negotiateWireVersion(""))
+}